@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -12,84 +13,82 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"httpfromtcp/internal/accesslog"
+	"httpfromtcp/internal/compress"
+	"httpfromtcp/internal/fileserver"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 )
 
-const port = 42069
+const (
+	port            = 42069
+	shutdownTimeout = 10 * time.Second
+)
 
 func main() {
-	srv, err := server.Serve(port, handler)
+	accessLog := accesslog.New(os.Stdout, accesslog.FormatCombined)
+	handler := server.Chain(newRouter().ServeRequest,
+		accessLog.Middleware,
+		compress.Middleware(),
+	)
+	srv, err := server.ServeWithConfig(port, handler, server.Config{OnRejected: accessLog.LogRejected})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer srv.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
-	log.Println("Server gracefully stopped")
-}
-
-func handler(req *request.Request, w *response.Writer) error {
-	// Check if it's a proxy request
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		return handleProxy(req, w)
-	}
-
-	switch req.RequestLine.RequestTarget {
-	case "/video":
-		return handleVideo(w)
-	case "/yourproblem":
-		return handleYourProblem(w)
-	case "/myproblem":
-		return handleMyProblem(w)
-	default:
-		return handleSuccess(w)
-	}
-}
 
-func handleVideo(w *response.Writer) error {
-	// Read video file
-	videoData, err := os.ReadFile("assets/vim.mp4")
+	// Give in-flight requests a chance to finish before exiting
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	forced, err := srv.Shutdown(ctx)
 	if err != nil {
-		fmt.Println("Error reading video:", err)
-		return err
-	}
-
-	// Write status line
-	err = w.WriteStatusLine(response.StatusOK)
-	if err != nil {
-		return err
+		log.Printf("Shutdown timed out, %d connections cut off: %v", forced, err)
+		return
 	}
+	log.Println("Server gracefully stopped")
+}
 
-	// Get headers with correct content length
-	headers := response.GetDefaultHeaders(len(videoData))
-	headers.Set("content-type", "video/mp4")
-
-	// Write headers
-	err = w.WriteHeaders(headers)
-	if err != nil {
-		return err
-	}
+func newRouter() *router.Router {
+	rt := router.New()
+	rt.Handle("GET", "/", handleSuccess)
+	rt.Handle("GET", "/video", handleVideo)
+	assets := fileserver.New("assets")
+	assets.Prefix = "/assets"
+	rt.Handle("GET", "/assets/*", assets.ServeRequest)
+	rt.Handle("GET", "/yourproblem", handleYourProblem)
+	rt.Handle("GET", "/myproblem", handleMyProblem)
+	rt.Handle("GET", "/httpbin/*", handleProxy)
+	return rt
+}
 
-	// Write video data
-	_, err = w.WriteBody(videoData)
-	return err
+func handleVideo(req *request.Request, w *response.Writer) error {
+	return fileserver.ServeFile(req, w, "assets/vim.mp4")
 }
 
 func handleProxy(req *request.Request, w *response.Writer) error {
-	// Extract the path after /httpbin
-	path := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
+	// Extract the path after /httpbin, keeping the query
+	path := strings.TrimPrefix(req.URL.RawPath, "/httpbin")
 	url := "https://httpbin.org" + path
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
 
 	fmt.Println("Proxying to:", url)
 
-	// Make request to httpbin.org
-	resp, err := http.Get(url)
+	// Make request to httpbin.org, abandoning it if our client goes away
+	upstreamReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(upstreamReq)
 	if err != nil {
 		return err
 	}
@@ -173,7 +172,7 @@ func handleProxy(req *request.Request, w *response.Writer) error {
 	return err
 }
 
-func handleYourProblem(w *response.Writer) error {
+func handleYourProblem(req *request.Request, w *response.Writer) error {
 	html := `<html>
   <head>
     <title>400 Bad Request</title>
@@ -203,7 +202,7 @@ func handleYourProblem(w *response.Writer) error {
 	return err
 }
 
-func handleMyProblem(w *response.Writer) error {
+func handleMyProblem(req *request.Request, w *response.Writer) error {
 	html := `<html>
   <head>
     <title>500 Internal Server Error</title>
@@ -233,7 +232,7 @@ func handleMyProblem(w *response.Writer) error {
 	return err
 }
 
-func handleSuccess(w *response.Writer) error {
+func handleSuccess(req *request.Request, w *response.Writer) error {
 	html := `<html>
   <head>
     <title>200 OK</title>
@@ -35,9 +35,9 @@ func main() {
 		fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
 
 		fmt.Println("Headers:")
-		for key, value := range req.Headers {
+		req.Headers.Each(func(key, value string) {
 			fmt.Printf("- %s: %s\n", key, value)
-		}
+		})
 
 		fmt.Println("Body:")
 		fmt.Println(string(req.Body))
@@ -0,0 +1,197 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Format selects how each access log line is written.
+type Format int
+
+const (
+	// FormatCommon is Apache's Common Log Format.
+	FormatCommon Format = 0
+	// FormatCombined is Common Log Format plus the Referer and User-Agent.
+	FormatCombined Format = 1
+	// FormatJSON writes one JSON object per line, with every field.
+	FormatJSON Format = 2
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Entry is everything recorded about one request.
+type Entry struct {
+	Time       time.Time     `json:"time"`
+	RemoteAddr string        `json:"remote_addr"`
+	Method     string        `json:"method"`
+	Target     string        `json:"target"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int           `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	UserAgent  string        `json:"user_agent"`
+	Referer    string        `json:"referer"`
+}
+
+// Logger writes access log entries to an io.Writer. It is safe for
+// concurrent use; each entry is written with a single Write call.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format Format
+}
+
+func New(out io.Writer, format Format) *Logger {
+	return &Logger{out: out, format: format}
+}
+
+// Middleware logs every request that passes through it once the inner
+// handler has returned, or panicked. A panic is logged as the 500 the
+// server answers it with, unless the response had already started, and
+// then passed on.
+//
+// Requests the server rejects before they reach any handler, because they
+// couldn't be parsed, are logged only if LogRejected is set as the
+// server's Config.OnRejected.
+func (l *Logger) Middleware(next server.Handler) server.Handler {
+	return func(req *request.Request, w *response.Writer) error {
+		start := time.Now()
+		defer func() {
+			status := w.StatusCode()
+			v := recover()
+			if v != nil && !w.Started() {
+				status = response.StatusInternalServerError
+			}
+
+			l.Log(Entry{
+				Time:       start,
+				RemoteAddr: req.RemoteAddr,
+				Method:     req.RequestLine.Method,
+				Target:     req.RequestLine.RequestTarget,
+				Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+				Status:     int(status),
+				Bytes:      w.BytesWritten(),
+				Duration:   time.Since(start),
+				UserAgent:  req.Headers.Get("User-Agent"),
+				Referer:    req.Headers.Get("Referer"),
+			})
+			if v != nil {
+				panic(v)
+			}
+		}()
+		return next(req, w)
+	}
+}
+
+// LogRejected logs the error response the server sent to a request it
+// couldn't parse. There's no request line to record, so it shows as "-".
+// It fits server.Config.OnRejected.
+func (l *Logger) LogRejected(remoteAddr string, w *response.Writer) {
+	l.Log(Entry{
+		Time:       time.Now(),
+		RemoteAddr: remoteAddr,
+		Status:     int(w.StatusCode()),
+		Bytes:      w.BytesWritten(),
+	})
+}
+
+// Middleware is shorthand for New(out, format).Middleware.
+func Middleware(out io.Writer, format Format) server.Middleware {
+	return New(out, format).Middleware
+}
+
+// Log writes a single entry.
+func (l *Logger) Log(e Entry) error {
+	line, err := l.formatEntry(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.out.Write(line)
+	return err
+}
+
+func (l *Logger) formatEntry(e Entry) ([]byte, error) {
+	switch l.format {
+	case FormatJSON:
+		line, err := json.Marshal(struct {
+			Entry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration) / float64(time.Millisecond)})
+		if err != nil {
+			return nil, err
+		}
+		return append(line, '\n'), nil
+
+	case FormatCommon, FormatCombined:
+		var b strings.Builder
+		// host ident authuser [time] "request line" status bytes
+		fmt.Fprintf(&b, "%s - - [%s] %s %s %s",
+			clfHost(e.RemoteAddr),
+			e.Time.Format(clfTimeLayout),
+			clfRequest(e),
+			clfStatus(e.Status),
+			clfBytes(e.Bytes))
+		if l.format == FormatCombined {
+			fmt.Fprintf(&b, " %s %s", clfQuoted(e.Referer), clfQuoted(e.UserAgent))
+		}
+		b.WriteByte('\n')
+		return []byte(b.String()), nil
+
+	default:
+		return nil, fmt.Errorf("accesslog: unknown format %d", l.format)
+	}
+}
+
+// clfHost strips the port from a remote address.
+func clfHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	if addr == "" {
+		return "-"
+	}
+	return addr
+}
+
+// clfRequest quotes the request line, or gives "-" if there wasn't one.
+func clfRequest(e Entry) string {
+	if e.Method == "" {
+		return `"-"`
+	}
+	return strconv.Quote(e.Method + " " + e.Target + " " + e.Proto)
+}
+
+func clfStatus(status int) string {
+	if status == 0 {
+		return "-"
+	}
+	return strconv.Itoa(status)
+}
+
+// clfBytes follows %b, which logs "-" rather than 0.
+func clfBytes(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+func clfQuoted(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return strconv.Quote(value)
+}
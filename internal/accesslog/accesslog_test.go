@@ -0,0 +1,110 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+var testEntry = Entry{
+	Time:       time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+	RemoteAddr: "127.0.0.1:54321",
+	Method:     "GET",
+	Target:     "/apache_pb.gif",
+	Proto:      "HTTP/1.1",
+	Status:     200,
+	Bytes:      2326,
+	Duration:   1500 * time.Microsecond,
+	UserAgent:  "Mozilla/4.08",
+	Referer:    "http://www.example.com/start.html",
+}
+
+func TestFormats(t *testing.T) {
+	// Test: Common Log Format
+	var out bytes.Buffer
+	require.NoError(t, New(&out, FormatCommon).Log(testEntry))
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 2326`+"\n", out.String())
+
+	// Test: Combined Log Format
+	out.Reset()
+	require.NoError(t, New(&out, FormatCombined).Log(testEntry))
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`+"\n", out.String())
+
+	// Test: Missing values are logged as "-"
+	out.Reset()
+	e := testEntry
+	e.Bytes, e.UserAgent, e.Referer = 0, "", ""
+	require.NoError(t, New(&out, FormatCombined).Log(e))
+	assert.True(t, strings.HasSuffix(out.String(), `200 - "-" "-"`+"\n"), out.String())
+
+	// Test: JSON lines
+	out.Reset()
+	require.NoError(t, New(&out, FormatJSON).Log(testEntry))
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, "127.0.0.1:54321", got["remote_addr"])
+	assert.Equal(t, "/apache_pb.gif", got["target"])
+	assert.Equal(t, float64(200), got["status"])
+	assert.Equal(t, float64(2326), got["bytes"])
+	assert.Equal(t, 1.5, got["duration_ms"])
+	assert.Equal(t, "Mozilla/4.08", got["user_agent"])
+}
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	h := Middleware(&out, FormatCombined)(func(req *request.Request, w *response.Writer) error {
+		body := []byte("hello")
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+			return err
+		}
+		_, err := w.WriteBody(body)
+		return err
+	})
+
+	req, err := request.RequestFromReader(strings.NewReader(
+		"GET /hi HTTP/1.1\r\nUser-Agent: curl/8.5.0\r\nReferer: /from\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	require.NoError(t, h(req, response.NewWriter(&bytes.Buffer{})))
+	line := out.String()
+	assert.True(t, strings.HasPrefix(line, "10.0.0.1 - - ["), line)
+	assert.Contains(t, line, `"GET /hi HTTP/1.1" 200 5 "/from" "curl/8.5.0"`)
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	var out bytes.Buffer
+	h := Middleware(&out, FormatCommon)(func(req *request.Request, w *response.Writer) error {
+		panic("boom")
+	})
+	req, err := request.RequestFromReader(strings.NewReader("GET /crash HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: The panic is logged as a 500 and passed on to the server
+	assert.PanicsWithValue(t, "boom", func() {
+		h(req, response.NewWriter(&bytes.Buffer{}))
+	})
+	assert.Contains(t, out.String(), `"GET /crash HTTP/1.1" 500 -`)
+}
+
+func TestLogRejected(t *testing.T) {
+	var out bytes.Buffer
+	w := response.NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteError(response.StatusBadRequest, "Bad Request"))
+
+	New(&out, FormatCommon).LogRejected("10.0.0.1:1234", w)
+	line := out.String()
+	assert.True(t, strings.HasPrefix(line, "10.0.0.1 - - ["), line)
+	assert.Contains(t, line, `] "-" 400 12`)
+}
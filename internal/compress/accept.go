@@ -0,0 +1,65 @@
+package compress
+
+import (
+	"strconv"
+	"strings"
+)
+
+// supported lists the codings we can produce, most preferred first. Ties
+// in q-value go to the earlier one, so brotli, which compresses text best,
+// wins when the client is indifferent.
+var supported = []string{"br", "gzip", "deflate"}
+
+// negotiate picks the coding to use for an Accept-Encoding value, or ""
+// to send the body as is (RFC 9110 12.5.3). A missing header means the
+// client didn't ask for compression, so none is used.
+func negotiate(acceptEncoding string) string {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+
+	weights := parseAcceptEncoding(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := weights[coding]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding maps each listed coding, lowercased, to its q-value.
+// Entries with an invalid q-value are dropped.
+func parseAcceptEncoding(value string) map[string]float64 {
+	weights := map[string]float64{}
+	for _, entry := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(entry, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		valid := true
+		for _, param := range strings.Split(params, ";") {
+			name, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				valid = false
+				break
+			}
+			q = parsed
+		}
+		if valid {
+			weights[coding] = q
+		}
+	}
+	return weights
+}
@@ -0,0 +1,176 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMinSize is the body size below which compressing isn't worth
+// the CPU or the gzip header.
+const DefaultMinSize = 1024
+
+// Compressor compresses response bodies with brotli, gzip or deflate, as
+// the client's Accept-Encoding allows.
+//
+// Only compressible types (text, JSON, JavaScript, XML, SVG, WebAssembly)
+// are compressed; media and archives already are. Bodies with a
+// Content-Length below MinSize are left alone, as are partial responses
+// and ones that already have a Content-Encoding or Cache-Control:
+// no-transform. A compressed body loses its Content-Length and goes out
+// chunked, and a strong ETag is weakened since the bytes differ. A 304
+// gets the Vary: Accept-Encoding and ETag its 200 would have had.
+type Compressor struct {
+	// MinSize is the smallest Content-Length worth compressing. Chunked
+	// bodies, whose size isn't known, are always compressed.
+	MinSize int
+	// Level is the compression level, from gzip.BestSpeed to
+	// gzip.BestCompression. Brotli uses it as is, its own scale running to
+	// brotli.BestCompression; gzip.DefaultCompression means brotli's
+	// default.
+	Level int
+}
+
+func New() *Compressor {
+	return &Compressor{MinSize: DefaultMinSize, Level: gzip.DefaultCompression}
+}
+
+// Middleware compresses the responses of next.
+func (c *Compressor) Middleware(next server.Handler) server.Handler {
+	return func(req *request.Request, w *response.Writer) error {
+		coding := negotiate(req.Headers.Get("Accept-Encoding"))
+
+		w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+			// A 304 must carry the Vary its 200 would have (RFC 9110
+			// 15.4.5)
+			if statusCode == response.StatusNotModified {
+				if mayHaveVaried(h) {
+					addVary(h)
+					// and the ETag it would have had, so a cache
+					// refreshing a compressed copy isn't handed a strong
+					// validator for it
+					if coding != "" {
+						weakenETag(h)
+					}
+				}
+				return
+			}
+			if !c.compressible(statusCode, h) {
+				return
+			}
+			// Whether or not this client gets it compressed, others may,
+			// so caches must key on Accept-Encoding
+			addVary(h)
+			if coding == "" {
+				return
+			}
+
+			h.Set("Content-Encoding", coding)
+			weakenETag(h)
+			w.SetBodyEncoder(func(out io.Writer) response.BodyEncoder {
+				return c.newEncoder(coding, out)
+			})
+		})
+		return next(req, w)
+	}
+}
+
+// Middleware is shorthand for New().Middleware.
+func Middleware() server.Middleware {
+	return New().Middleware
+}
+
+func (c *Compressor) compressible(statusCode response.StatusCode, h *headers.Headers) bool {
+	if statusCode < 200 || statusCode == response.StatusNoContent ||
+		statusCode == response.StatusPartialContent || statusCode == response.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		headers.HasToken(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	if !compressibleType(h.Get("Content-Type")) {
+		return false
+	}
+
+	if headers.HasToken(h.Get("Transfer-Encoding"), "chunked") {
+		return true
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	return err == nil && n >= c.MinSize
+}
+
+// mayHaveVaried reports whether the representation a 304 stands for could
+// have been compressed. A 304 has no body to size, and usually no
+// Content-Type, so it is assumed to have been unless the headers it does
+// carry rule that out. A needless Vary only costs a cache some hits.
+func mayHaveVaried(h *headers.Headers) bool {
+	if h.Get("Content-Encoding") != "" || headers.HasToken(h.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	return contentType == "" || compressibleType(contentType)
+}
+
+// weakenETag marks a strong ETag weak, since compressed bytes differ from
+// the ones it was computed over.
+func weakenETag(h *headers.Headers) {
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+func addVary(h *headers.Headers) {
+	if !headers.HasToken(h.Get("Vary"), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+}
+
+func (c *Compressor) newEncoder(coding string, out io.Writer) response.BodyEncoder {
+	if coding == "br" {
+		level := c.Level
+		if level < brotli.BestSpeed || level > brotli.BestCompression {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(out, level)
+	}
+	if coding == "deflate" {
+		// HTTP's "deflate" is the zlib format, not raw deflate
+		if zw, err := zlib.NewWriterLevel(out, c.Level); err == nil {
+			return zw
+		}
+		return zlib.NewWriter(out)
+	}
+	if gw, err := gzip.NewWriterLevel(out, c.Level); err == nil {
+		return gw
+	}
+	return gzip.NewWriter(out)
+}
+
+// compressibleType reports whether a Content-Type is worth compressing.
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "image/svg+xml", "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate", "gzip"},
+		{"br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"zstd", ""},
+		{"identity", ""},
+		{"deflate;q=1.0, gzip;q=0.5", "deflate"},
+		{"GZIP;Q=0.8", "gzip"},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"*", "br"},
+		{"*;q=0.5, br;q=0, gzip;q=0", "deflate"},
+		{"gzip;q=0, *;q=0", ""},
+		// An invalid q-value drops the entry
+		{"gzip;q=2, deflate", "deflate"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiate(tt.acceptEncoding), tt.acceptEncoding)
+	}
+}
+
+// serve runs handler behind the compressor and parses what it wrote.
+func serve(t *testing.T, acceptEncoding string, handler server.Handler) (*http.Response, []byte) {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	require.NoError(t, server.Chain(handler, Middleware())(req, w))
+	assert.True(t, w.Finished())
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func fixed(contentType, body string, extra ...string) server.Handler {
+	return func(req *request.Request, w *response.Writer) error {
+		err := w.WriteStatusLine(response.StatusOK)
+		if err != nil {
+			return err
+		}
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("content-type", contentType)
+		for i := 0; i+1 < len(extra); i += 2 {
+			h.Set(extra[i], extra[i+1])
+		}
+		err = w.WriteHeaders(h)
+		if err != nil {
+			return err
+		}
+		// Written in two parts to check the encoder spans WriteBody calls
+		_, err = w.WriteBody([]byte(body[:len(body)/2]))
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteBody([]byte(body[len(body)/2:]))
+		return err
+	}
+}
+
+func TestCompressFixedLength(t *testing.T) {
+	page := strings.Repeat("<p>hello, compression</p>\n", 200)
+
+	// Test: gzip replaces Content-Length with chunked encoding
+	resp, body := serve(t, "gzip, deflate", fixed("text/html", page, "etag", `"v1"`))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	assert.Less(t, len(body), len(page))
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: deflate is the zlib format
+	resp, body = serve(t, "deflate", fixed("application/json", page))
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	fr, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(fr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: brotli, preferred when the client takes it
+	resp, body = serve(t, "gzip, deflate, br", fixed("text/html", page))
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+	assert.Less(t, len(body), len(page))
+	decoded, err = io.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+
+	// Test: Without Accept-Encoding the body is untouched, but still varies
+	resp, body = serve(t, "", fixed("text/html", page))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(len(page)), resp.ContentLength)
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, page, string(body))
+
+	// Test: Small bodies, compressed types and already encoded bodies are
+	// left alone
+	skipped := []server.Handler{
+		fixed("text/html", "tiny"),
+		fixed("video/mp4", page),
+		fixed("text/html", page, "content-encoding", "gzip"),
+		fixed("text/html", page, "cache-control", "no-transform"),
+	}
+	for _, handler := range skipped {
+		resp, body = serve(t, "gzip", handler)
+		assert.NotEqual(t, []string{"chunked"}, resp.TransferEncoding)
+		assert.Empty(t, resp.Header.Get("Vary"))
+		assert.Equal(t, resp.ContentLength, int64(len(body)))
+	}
+}
+
+func TestCompressChunked(t *testing.T) {
+	handler := func(req *request.Request, w *response.Writer) error {
+		err := w.WriteStatusLine(response.StatusOK)
+		if err != nil {
+			return err
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Set("transfer-encoding", "chunked")
+		h.Set("trailer", "X-Count")
+		err = w.WriteHeaders(h)
+		if err != nil {
+			return err
+		}
+		for _, part := range []string{"one ", "two ", "three"} {
+			_, err = w.WriteChunkedBody([]byte(part))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = w.WriteChunkedBodyDone()
+		if err != nil {
+			return err
+		}
+		trailers := response.GetDefaultHeaders(0)
+		trailers.Delete("content-length")
+		trailers.Delete("content-type")
+		trailers.Set("X-Count", "3")
+		return w.WriteTrailers(trailers)
+	}
+
+	resp, body := serve(t, "gzip", handler)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "3", resp.Trailer.Get("X-Count"))
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "one two three", string(decoded))
+
+	// Test: Each flush of the encoder is one chunk on the wire: one per
+	// WriteChunkedBody, one for the end of the stream, then the last chunk
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, Middleware()(handler)(req, response.NewWriter(&out)))
+	_, raw, ok := strings.Cut(out.String(), "\r\n\r\n")
+	require.True(t, ok)
+	var sizes []int64
+	for {
+		line, rest, ok := strings.Cut(raw, "\r\n")
+		require.True(t, ok)
+		size, err := strconv.ParseInt(line, 16, 64)
+		require.NoError(t, err)
+		sizes = append(sizes, size)
+		if size == 0 {
+			break
+		}
+		raw = rest[size+2:]
+	}
+	assert.Len(t, sizes, 5, sizes)
+}
+
+func TestCompressNotModified(t *testing.T) {
+	notModified := func(extra ...string) server.Handler {
+		return func(req *request.Request, w *response.Writer) error {
+			if err := w.WriteStatusLine(response.StatusNotModified); err != nil {
+				return err
+			}
+			h := headers.NewHeaders()
+			h.Set("ETag", `"v1"`)
+			for i := 0; i+1 < len(extra); i += 2 {
+				h.Set(extra[i], extra[i+1])
+			}
+			return w.WriteHeaders(h)
+		}
+	}
+
+	// Test: A 304 gets the Vary its 200 would have, whoever asks, and
+	// the same ETag: weakened if the 200 would have been compressed
+	for _, tt := range []struct{ acceptEncoding, etag string }{{"gzip", `W/"v1"`}, {"", `"v1"`}} {
+		resp, body := serve(t, tt.acceptEncoding, notModified())
+		assert.Equal(t, 304, resp.StatusCode)
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		assert.Equal(t, tt.etag, resp.Header.Get("ETag"))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Empty(t, body)
+	}
+	resp, _ := serve(t, "gzip", notModified("Content-Type", "text/html", "Vary", "Cookie"))
+	assert.Equal(t, []string{"Cookie", "Accept-Encoding"}, resp.Header.Values("Vary"))
+
+	// Test: Unless the representation couldn't have been compressed
+	resp, _ = serve(t, "gzip", notModified("Content-Type", "video/mp4"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	resp, _ = serve(t, "gzip", notModified("Cache-Control", "no-transform"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+}
@@ -0,0 +1,164 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+)
+
+// SameSite is the SameSite attribute of a Set-Cookie.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and lets the browser decide.
+	SameSiteDefault SameSite = 0
+	SameSiteLax     SameSite = 1
+	SameSiteStrict  SameSite = 2
+	// SameSiteNone requires Secure.
+	SameSiteNone SameSite = 3
+)
+
+// Cookie is a cookie as sent by a client or set by a server (RFC 6265).
+// Only Name and Value come back from a client; the rest are Set-Cookie
+// attributes.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves the attribute out; a negative
+	// value deletes the cookie straight away ("Max-Age=0").
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Parse parses the value of a request Cookie header, "a=1; b=2". Pairs
+// that aren't valid are skipped, the way browsers treat them.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		pair = strings.TrimSpace(pair)
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		value, ok = parseValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Valid reports why c can't be sent in a Set-Cookie header, if it can't.
+func (c *Cookie) Valid() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+	if _, ok := parseValue(c.Value); !ok {
+		return fmt.Errorf("invalid cookie value for %q", c.Name)
+	}
+	if strings.ContainsAny(c.Path, ";") || hasCTL(c.Path) {
+		return fmt.Errorf("invalid cookie path %q", c.Path)
+	}
+	if c.Domain != "" && !isValidDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("invalid cookie domain %q", c.Domain)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("cookie %q has SameSite=None without Secure", c.Name)
+	}
+	return nil
+}
+
+// String returns c serialized as a Set-Cookie header value. It does not
+// validate c; call Valid first.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteString("=")
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + headers.FormatTime(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	return b.String()
+}
+
+// parseValue strips optional surrounding quotes and checks the value is
+// made of cookie-octets.
+func parseValue(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !isCookieOctet(value[i]) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// isCookieOctet excludes CTLs, whitespace, DQUOTE, comma, semicolon and
+// backslash.
+func isCookieOctet(c byte) bool {
+	return c == 0x21 ||
+		(c >= 0x23 && c <= 0x2b) ||
+		(c >= 0x2d && c <= 0x3a) ||
+		(c >= 0x3c && c <= 0x5b) ||
+		(c >= 0x5d && c <= 0x7e)
+}
+
+func isValidDomain(domain string) bool {
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func hasCTL(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cookies := Parse(`session=abc123; theme="dark";  empty=; bad name=x; novalue; lang=en`)
+	require.Len(t, cookies, 4)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	// Quotes are stripped
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "empty", cookies[2].Name)
+	assert.Equal(t, "", cookies[2].Value)
+	assert.Equal(t, "lang", cookies[3].Name)
+
+	// Test: Values with characters outside cookie-octet are skipped
+	assert.Empty(t, Parse(`a=b c; d=e,f`))
+}
+
+func TestString(t *testing.T) {
+	c := &Cookie{
+		Name:     "id",
+		Value:    "a3fWa",
+		Path:     "/",
+		Domain:   ".example.com",
+		Expires:  time.Date(2015, 10, 21, 7, 28, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteStrict,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 05:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", c.String())
+
+	// Test: Negative MaxAge deletes the cookie
+	assert.Equal(t, "id=; Max-Age=0", (&Cookie{Name: "id", MaxAge: -1}).String())
+}
+
+func TestValid(t *testing.T) {
+	bad := []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "a b", Value: "x"},
+		{Name: "a", Value: "x;y"},
+		{Name: "a", Value: "x", Path: "/a;b"},
+		{Name: "a", Value: "x", Domain: "exa mple.com"},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+	}
+	for _, c := range bad {
+		assert.Error(t, c.Valid(), c.String())
+	}
+	assert.NoError(t, (&Cookie{Name: "a", Value: "x", SameSite: SameSiteNone, Secure: true}).Valid())
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileserver
+
+import "os"
+
+// fileID returns 0: there is no inode number to go by.
+func fileID(info os.FileInfo) uint64 {
+	return 0
+}
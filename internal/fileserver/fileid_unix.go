@@ -0,0 +1,16 @@
+//go:build unix
+
+package fileserver
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the file's inode number.
+func fileID(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,262 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+const indexFile = "index.html"
+
+// FileServer serves files from a directory tree.
+//
+// The request path, less Prefix, is mapped onto the root directory. Paths
+// can't climb out of root, either with ".." or through a symlink. A
+// directory is served by its index.html, or else by an HTML listing if
+// ListDirectories is set.
+type FileServer struct {
+	root string
+	// Prefix is removed from the request path before it is mapped onto the
+	// root, for serving a tree under e.g. "/static/". Requests without it
+	// get a 404.
+	Prefix string
+	// ListDirectories serves an HTML listing for directories that have no
+	// index.html, instead of a 404.
+	ListDirectories bool
+}
+
+func New(root string) *FileServer {
+	return &FileServer{root: root}
+}
+
+// ServeRequest is a server.Handler serving the file named by the request
+// path.
+func (fs *FileServer) ServeRequest(req *request.Request, w *response.Writer) error {
+	rel, ok := strings.CutPrefix(req.URL.Path, fs.Prefix)
+	if !ok || strings.ContainsAny(rel, "\x00\\") {
+		return notFound(w)
+	}
+	name, err := fs.resolve(rel)
+	if err != nil {
+		return notFound(w)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return notFound(w)
+	}
+	if !info.IsDir() {
+		return ServeFile(req, w, name)
+	}
+
+	// Relative links in a directory's page only work from a path ending
+	// in a slash
+	if !strings.HasSuffix(req.URL.Path, "/") {
+		location := req.URL.RawPath + "/"
+		if req.URL.RawQuery != "" {
+			location += "?" + req.URL.RawQuery
+		}
+		return w.WriteText(response.StatusMovedPermanently, "Moved Permanently\n", field("location", location))
+	}
+
+	index := filepath.Join(name, indexFile)
+	if info, err := os.Stat(index); err == nil && !info.IsDir() {
+		return ServeFile(req, w, index)
+	}
+	if fs.ListDirectories {
+		return listDirectory(w, req.URL.Path, name)
+	}
+	return notFound(w)
+}
+
+// resolve maps a decoded request path onto a filesystem path under root.
+func (fs *FileServer) resolve(rel string) (string, error) {
+	// Cleaning a rooted path drops any ".." that would climb above it
+	name := filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+rel)))
+
+	// A symlink inside the tree may still point out of it
+	root, err := filepath.EvalSymlinks(fs.root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return "", err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside the root", rel)
+	}
+	return resolved, nil
+}
+
+// ServeFile serves the named file, streaming it from disk, with a FileETag.
+func ServeFile(req *request.Request, w *response.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return notFound(w)
+		}
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return notFound(w)
+	}
+	return ServeContent(req, w, info.Name(), info.ModTime(), FileETag(info), f)
+}
+
+// ServeBytes serves data like ServeContent, with a strong ETag computed
+// from it.
+func ServeBytes(req *request.Request, w *response.Writer, name string, modtime time.Time, data []byte) error {
+	return ServeContent(req, w, name, modtime, StrongETag(data), bytes.NewReader(data))
+}
+
+// ServeContent serves content, honouring conditional and Range requests.
+// The Content-Type comes from name's extension, or failing that from
+// sniffing the first bytes of content. A non-zero modtime is sent as
+// Last-Modified and a non-empty etag as ETag; both are used to evaluate
+// the request's preconditions and If-Range.
+func ServeContent(req *request.Request, w *response.Writer, name string, modtime time.Time, etag string, content io.ReadSeeker) error {
+	validators := headers.NewHeaders()
+	if etag != "" {
+		validators.Set("etag", etag)
+	}
+	if !modtime.IsZero() {
+		validators.Set("last-modified", headers.FormatTime(modtime))
+	}
+
+	// 304 and 412 go out without a body
+	switch status := checkPreconditions(req, modtime, etag); status {
+	case response.StatusNotModified:
+		err := w.WriteStatusLine(status)
+		if err != nil {
+			return err
+		}
+		return w.WriteHeaders(validators)
+	case response.StatusPreconditionFailed:
+		return writeHead(w, status, validators, 0)
+	}
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	contentType := typeByExtension(name)
+	if contentType == "" {
+		buf := make([]byte, sniffLen)
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(content, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		contentType = detectContentType(buf[:n])
+	}
+
+	hdrs := response.GetDefaultHeaders(0)
+	hdrs.Set("content-type", contentType)
+	hdrs.Set("accept-ranges", "bytes")
+	validators.Each(func(name, value string) {
+		hdrs.Add(name, value)
+	})
+
+	ranges, err := requestedRanges(req, size, modtime, etag)
+	if errors.Is(err, errUnsatisfiable) {
+		return w.WriteText(response.StatusRangeNotSatisfiable, "Range Not Satisfiable\n",
+			field("content-range", fmt.Sprintf("bytes */%d", size)))
+	}
+
+	switch len(ranges) {
+	case 0:
+		err = writeHead(w, response.StatusOK, hdrs, size)
+		if err != nil {
+			return err
+		}
+		return copyRange(w, content, byteRange{start: 0, length: size})
+
+	case 1:
+		hdrs.Set("content-range", ranges[0].contentRange(size))
+		err = writeHead(w, response.StatusPartialContent, hdrs, ranges[0].length)
+		if err != nil {
+			return err
+		}
+		return copyRange(w, content, ranges[0])
+
+	default:
+		body := newMultipartRanges(ranges, contentType, size)
+		hdrs.Set("content-type", body.contentType())
+		err = writeHead(w, response.StatusPartialContent, hdrs, body.length())
+		if err != nil {
+			return err
+		}
+		return body.writeTo(w, content)
+	}
+}
+
+func writeHead(w *response.Writer, statusCode response.StatusCode, hdrs *headers.Headers, contentLength int64) error {
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	hdrs.Set("content-length", strconv.FormatInt(contentLength, 10))
+	return w.WriteHeaders(hdrs)
+}
+
+func listDirectory(w *response.Writer, urlPath, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(&b, "<!doctype html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if urlPath != "/" {
+		b.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		// "./" keeps a name like "a:b" from reading as a scheme
+		href := "./" + (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+
+	return w.WriteText(response.StatusOK, b.String(), field("content-type", "text/html; charset=utf-8"))
+}
+
+func notFound(w *response.Writer) error {
+	return w.WriteText(response.StatusNotFound, "Not Found\n", nil)
+}
+
+// field returns headers holding the one field name: value.
+func field(name, value string) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set(name, value)
+	return h
+}
@@ -0,0 +1,313 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+func serve(t *testing.T, fs *FileServer, raw string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	require.NoError(t, fs.ServeRequest(req, w))
+	assert.True(t, w.Finished(), raw)
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func get(path string) string {
+	return "GET " + path + " HTTP/1.1\r\n\r\n"
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+}
+
+func TestFileServer(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	big := strings.Repeat("0123456789", 10000)
+	writeFile(t, filepath.Join(root, "style.css"), "body {}")
+	writeFile(t, filepath.Join(root, "big.txt"), big)
+	writeFile(t, filepath.Join(root, "image"), "\x89PNG\r\n\x1a\nrest")
+	writeFile(t, filepath.Join(root, "site", "index.html"), "<h1>home</h1>")
+	writeFile(t, filepath.Join(root, "files", "a b.txt"), "a")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "files", "sub"), 0o755))
+	writeFile(t, filepath.Join(dir, "secret.txt"), "secret")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	fs := New(root)
+	fs.Prefix = "/static"
+
+	// Test: Content-Type by extension
+	resp, body := serve(t, fs, get("/static/style.css"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "body {}", body)
+	etag := resp.Header.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `"`), etag)
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+
+	// Test: The file's ETag is strong, so If-Match and If-Range work
+	resp, _ = serve(t, fs, "GET /static/style.css HTTP/1.1\r\nIf-Match: "+etag+"\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, body = serve(t, fs, "GET /static/style.css HTTP/1.1\r\nRange: bytes=0-3\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "body", body)
+
+	// Test: Rewriting the file changes its ETag
+	writeFile(t, filepath.Join(root, "style.css"), "body {color: red}")
+	resp, _ = serve(t, fs, "GET /static/style.css HTTP/1.1\r\nIf-Match: "+etag+"\r\n\r\n")
+	assert.Equal(t, 412, resp.StatusCode)
+	writeFile(t, filepath.Join(root, "style.css"), "body {}")
+
+	// Test: Larger files are streamed whole
+	resp, body = serve(t, fs, get("/static/big.txt"))
+	assert.Equal(t, int64(len(big)), resp.ContentLength)
+	assert.Equal(t, big, body)
+
+	// Test: Content-Type by sniffing when there's no extension
+	resp, _ = serve(t, fs, get("/static/image"))
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+
+	// Test: Directories redirect to a trailing slash, then serve index.html
+	resp, _ = serve(t, fs, get("/static/site?x=1"))
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/static/site/?x=1", resp.Header.Get("Location"))
+	resp, body = serve(t, fs, get("/static/site/"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	// Test: No listing unless enabled
+	resp, _ = serve(t, fs, get("/static/files/"))
+	assert.Equal(t, 404, resp.StatusCode)
+
+	fs.ListDirectories = true
+	resp, body = serve(t, fs, get("/static/files/"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `<a href="./a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, body, `<a href="./sub/">sub/</a>`)
+
+	// Test: Nothing outside the root is reachable
+	for _, path := range []string{
+		"/static/../secret.txt",
+		"/static/%2e%2e/secret.txt",
+		"/static/..%2fsecret.txt",
+		"/static/link.txt",
+		"/secret.txt",
+		"/static/missing",
+	} {
+		resp, body = serve(t, fs, get(path))
+		assert.Equal(t, 404, resp.StatusCode, path)
+		assert.NotContains(t, body, "secret", path)
+	}
+}
+
+func serveContent(t *testing.T, raw string, modtime time.Time, content string) (*http.Response, string) {
+	t.Helper()
+	return serveContentETag(t, raw, modtime, "", content)
+}
+
+func serveContentETag(t *testing.T, raw string, modtime time.Time, etag, content string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	require.NoError(t, ServeContent(req, w, "data.txt", modtime, etag, strings.NewReader(content)))
+	assert.True(t, w.Finished(), raw)
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestServeContentRanges(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	withRange := func(extra string) string {
+		return "GET /data.txt HTTP/1.1\r\n" + extra + "\r\n"
+	}
+
+	// Test: No Range sends everything and advertises range support
+	resp, body := serveContent(t, withRange(""), modtime, content)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, content, body)
+
+	singles := []struct {
+		rangeHeader  string
+		body         string
+		contentRange string
+	}{
+		{"bytes=0-4", "01234", "bytes 0-4/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		// Ends past the content are clamped
+		{"bytes=18-100", "ij", "bytes 18-19/20"},
+		{"bytes=-50", content, "bytes 0-19/20"},
+		// Unsatisfiable ranges are dropped when another one is fine
+		{"bytes=50-60, 2-3", "23", "bytes 2-3/20"},
+		// Overlapping and adjacent ranges are merged
+		{"bytes=0-0,0-0,0-0", "0", "bytes 0-0/20"},
+		{"bytes=0-,0-,0-", content, "bytes 0-19/20"},
+		{"bytes=3-8, 0-4", "012345678", "bytes 0-8/20"},
+		{"bytes=5-9, 0-4", "0123456789", "bytes 0-9/20"},
+	}
+	for _, tt := range singles {
+		resp, body = serveContent(t, withRange("Range: "+tt.rangeHeader+"\r\n"), modtime, content)
+		assert.Equal(t, 206, resp.StatusCode, tt.rangeHeader)
+		assert.Equal(t, tt.contentRange, resp.Header.Get("Content-Range"), tt.rangeHeader)
+		assert.Equal(t, tt.body, body, tt.rangeHeader)
+	}
+
+	// Test: Malformed or foreign-unit ranges are ignored
+	for _, rangeHeader := range []string{"bytes=5-2", "bytes=x-3", "items=0-1", "bytes=-"} {
+		resp, body = serveContent(t, withRange("Range: "+rangeHeader+"\r\n"), modtime, content)
+		assert.Equal(t, 200, resp.StatusCode, rangeHeader)
+		assert.Equal(t, content, body, rangeHeader)
+	}
+
+	// Test: Nothing satisfiable is a 416
+	resp, _ = serveContent(t, withRange("Range: bytes=20-30, -0\r\n"), modtime, content)
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */20", resp.Header.Get("Content-Range"))
+
+	// Test: Several ranges come back as multipart/byteranges, in order
+	resp, body = serveContent(t, withRange("Range: bytes=10-12, 0-1, 11-11\r\n"), modtime, content)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	mr := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	for _, want := range []struct{ contentRange, body string }{{"bytes 0-1/20", "01"}, {"bytes 10-12/20", "abc"}} {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, want.body, string(data))
+	}
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: If-Range with the current date honours the range; a stale one
+	// gets the whole content
+	resp, _ = serveContent(t, withRange("Range: bytes=0-1\r\nIf-Range: Wed, 01 May 2024 12:00:00 GMT\r\n"), modtime, content)
+	assert.Equal(t, 206, resp.StatusCode)
+	resp, body = serveContent(t, withRange("Range: bytes=0-1\r\nIf-Range: Tue, 30 Apr 2024 12:00:00 GMT\r\n"), modtime, content)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, content, body)
+}
+
+func TestServeContentConditional(t *testing.T) {
+	const content = "hello, world"
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	etag := StrongETag([]byte(content))
+	const (
+		before = "Tue, 30 Apr 2024 12:00:00 GMT"
+		at     = "Wed, 01 May 2024 12:00:00 GMT"
+		after  = "Thu, 02 May 2024 12:00:00 GMT"
+	)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers string
+		want    int
+	}{
+		{"no conditions", "GET", "", 200},
+		{"If-None-Match hit", "GET", "If-None-Match: \"other\", " + etag + "\r\n", 304},
+		{"If-None-Match weak hit", "GET", "If-None-Match: W/" + etag + "\r\n", 304},
+		{"If-None-Match star", "GET", "If-None-Match: *\r\n", 304},
+		{"If-None-Match miss", "GET", "If-None-Match: \"other\"\r\n", 200},
+		{"If-None-Match hit on POST", "POST", "If-None-Match: " + etag + "\r\n", 412},
+		{"If-Modified-Since same", "GET", "If-Modified-Since: " + at + "\r\n", 304},
+		{"If-Modified-Since older", "GET", "If-Modified-Since: " + before + "\r\n", 200},
+		{"If-Modified-Since invalid", "GET", "If-Modified-Since: yesterday\r\n", 200},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"If-None-Match miss beats date", "GET", "If-None-Match: \"other\"\r\nIf-Modified-Since: " + after + "\r\n", 200},
+		{"If-Match hit", "GET", "If-Match: " + etag + "\r\n", 200},
+		{"If-Match weak is never strong", "GET", "If-Match: W/" + etag + "\r\n", 412},
+		{"If-Match miss", "GET", "If-Match: \"other\"\r\n", 412},
+		{"If-Unmodified-Since after", "GET", "If-Unmodified-Since: " + after + "\r\n", 200},
+		{"If-Unmodified-Since before", "GET", "If-Unmodified-Since: " + before + "\r\n", 412},
+		// If-Match takes precedence over If-Unmodified-Since
+		{"If-Match hit beats date", "GET", "If-Match: " + etag + "\r\nIf-Unmodified-Since: " + before + "\r\n", 200},
+		// A failed If-Match wins over a 304
+		{"If-Match before If-None-Match", "GET", "If-Match: \"other\"\r\nIf-None-Match: " + etag + "\r\n", 412},
+	}
+	for _, tt := range tests {
+		raw := tt.method + " /data.txt HTTP/1.1\r\n" + tt.headers + "\r\n"
+		resp, body := serveContentETag(t, raw, modtime, etag, content)
+		assert.Equal(t, tt.want, resp.StatusCode, tt.name)
+		if tt.want == 200 {
+			assert.Equal(t, content, body, tt.name)
+		} else {
+			assert.Empty(t, body, tt.name)
+		}
+		if tt.want != 412 {
+			assert.Equal(t, etag, resp.Header.Get("ETag"), tt.name)
+			assert.Equal(t, at, resp.Header.Get("Last-Modified"), tt.name)
+		}
+	}
+
+	// Test: If-Range accepts only a strong match of the current ETag
+	rangeReq := "GET /data.txt HTTP/1.1\r\nRange: bytes=0-4\r\nIf-Range: %s\r\n\r\n"
+	resp, body := serveContentETag(t, fmt.Sprintf(rangeReq, etag), modtime, etag, content)
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	resp, _ = serveContentETag(t, fmt.Sprintf(rangeReq, "W/"+etag), modtime, etag, content)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"\xff\xd8\xff\xe0", "image/jpeg"},
+		{"GIF89a...", "image/gif"},
+		{"\x00\x00\x00\x20ftypisom", "video/mp4"},
+		{"%PDF-1.7", "application/pdf"},
+		{"  <!DOCTYPE html><html>", "text/html; charset=utf-8"},
+		{"just some words\n", "text/plain; charset=utf-8"},
+		{"\x00\x01\x02\x03", "application/octet-stream"},
+		{"", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, detectContentType([]byte(tt.data)), tt.data)
+	}
+}
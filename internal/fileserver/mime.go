@@ -0,0 +1,103 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"strings"
+)
+
+// sniffLen is how much of a file detectContentType looks at.
+const sniffLen = 512
+
+// extensionTypes covers the types this server serves most, so they don't
+// depend on the system's mime tables.
+var extensionTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".txt":  "text/plain; charset=utf-8",
+	".xml":  "text/xml; charset=utf-8",
+	".svg":  "image/svg+xml",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".ico":  "image/x-icon",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+	".wasm": "application/wasm",
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+	".gz":   "application/gzip",
+}
+
+// typeByExtension returns the content type for name's extension, or "" if
+// it isn't known.
+func typeByExtension(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// signature is a magic number found at offset in a file.
+type signature struct {
+	prefix      []byte
+	offset      int
+	contentType string
+}
+
+var signatures = []signature{
+	{[]byte("\x89PNG\r\n\x1a\n"), 0, "image/png"},
+	{[]byte("\xff\xd8\xff"), 0, "image/jpeg"},
+	{[]byte("GIF87a"), 0, "image/gif"},
+	{[]byte("GIF89a"), 0, "image/gif"},
+	{[]byte("%PDF-"), 0, "application/pdf"},
+	{[]byte("PK\x03\x04"), 0, "application/zip"},
+	{[]byte("\x1f\x8b\x08"), 0, "application/gzip"},
+	{[]byte("\x1aE\xdf\xa3"), 0, "video/webm"},
+	{[]byte("ID3"), 0, "audio/mpeg"},
+	{[]byte("\x00asm"), 0, "application/wasm"},
+	// ISO base media files (MP4) start with a box size, then "ftyp"
+	{[]byte("ftyp"), 4, "video/mp4"},
+}
+
+// detectContentType guesses a content type from the first bytes of a file:
+// known magic numbers, then HTML, then text versus binary.
+func detectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+
+	for _, sig := range signatures {
+		if len(data) >= sig.offset+len(sig.prefix) && bytes.Equal(data[sig.offset:sig.offset+len(sig.prefix)], sig.prefix) {
+			return sig.contentType
+		}
+	}
+
+	trimmed := bytes.TrimLeft(data, "\t\n\x0c\r ")
+	lower := bytes.ToLower(trimmed)
+	for _, tag := range []string{"<!doctype html", "<html", "<head", "<body", "<!--"} {
+		if bytes.HasPrefix(lower, []byte(tag)) {
+			return "text/html; charset=utf-8"
+		}
+	}
+
+	for _, c := range data {
+		// Control bytes other than whitespace mean binary
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != 0x0c && c != 0x1b {
+			return "application/octet-stream"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,227 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// maxRanges caps how many ranges one request may ask for. Beyond it the
+// whole content is sent, which is what a client asking for that many
+// pieces is better off with anyway.
+const maxRanges = 32
+
+// errUnsatisfiable means none of the requested ranges overlap the content.
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// byteRange is a resolved range of the content, always within its size.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// requestedRanges returns the ranges to send for req, or nil to send the
+// whole content. Range headers that are malformed, in another unit, or
+// fail If-Range are ignored, as RFC 9110 14.2 allows.
+func requestedRanges(req *request.Request, size int64, modtime time.Time, etag string) ([]byteRange, error) {
+	value := req.Headers.Get("Range")
+	if value == "" || req.RequestLine.Method != "GET" {
+		return nil, nil
+	}
+	if ifRange := req.Headers.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, modtime, etag) {
+		return nil, nil
+	}
+	return parseRange(value, size)
+}
+
+// ifRangeMatches reports whether the If-Range validator still describes
+// the content: a strongly matching entity-tag, or the exact Last-Modified
+// date.
+func ifRangeMatches(value string, modtime time.Time, etag string) bool {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return etag != "" && etagsMatch(value, etag, true)
+	}
+	if modtime.IsZero() {
+		return false
+	}
+	t, err := headers.ParseTime(value)
+	if err != nil {
+		return false
+	}
+	return t.Equal(truncate(modtime))
+}
+
+// parseRange resolves a "bytes=" Range header against the content size.
+// It returns nil for a header that should be ignored, and errUnsatisfiable
+// if every range lies past the end. The ranges come back sorted, with
+// overlapping ones merged.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(value, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	count := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		count++
+		if count > maxRanges {
+			return nil, nil
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		if first == "" {
+			// "-n" is the last n bytes
+			n, err := parseOffset(last)
+			if err != nil {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := parseOffset(first)
+		if err != nil {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseOffset(last)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return coalesce(ranges), nil
+}
+
+// coalesce sorts ranges and merges those that overlap or touch, so no
+// byte is sent twice. Otherwise "bytes=0-,0-,..." would get the content
+// once per range (RFC 9110 14.2).
+func coalesce(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if end := last.start + last.length; r.start <= end {
+			last.length = max(end, r.start+r.length) - last.start
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// parseOffset parses a non-negative decimal byte offset.
+func parseOffset(s string) (int64, error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("invalid byte offset %q", s)
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// multipartRanges lays out a multipart/byteranges body, so its length is
+// known before any of it is written.
+type multipartRanges struct {
+	boundary string
+	ranges   []byteRange
+	// partHeaders[i] precedes ranges[i]; it starts with the CRLF that ends
+	// the previous part, if any
+	partHeaders []string
+	closing     string
+}
+
+func newMultipartRanges(ranges []byteRange, contentType string, size int64) *multipartRanges {
+	var b [16]byte
+	rand.Read(b[:])
+	m := &multipartRanges{boundary: hex.EncodeToString(b[:]), ranges: ranges}
+
+	for i, r := range ranges {
+		var part strings.Builder
+		if i > 0 {
+			part.WriteString("\r\n")
+		}
+		part.WriteString("--" + m.boundary + "\r\n")
+		part.WriteString("Content-Type: " + contentType + "\r\n")
+		part.WriteString("Content-Range: " + r.contentRange(size) + "\r\n\r\n")
+		m.partHeaders = append(m.partHeaders, part.String())
+	}
+	m.closing = "\r\n--" + m.boundary + "--\r\n"
+	return m
+}
+
+func (m *multipartRanges) contentType() string {
+	return "multipart/byteranges; boundary=" + m.boundary
+}
+
+func (m *multipartRanges) length() int64 {
+	n := int64(len(m.closing))
+	for i, r := range m.ranges {
+		n += int64(len(m.partHeaders[i])) + r.length
+	}
+	return n
+}
+
+func (m *multipartRanges) writeTo(w *response.Writer, content io.ReadSeeker) error {
+	for i, r := range m.ranges {
+		if _, err := w.WriteBody([]byte(m.partHeaders[i])); err != nil {
+			return err
+		}
+		if err := copyRange(w, content, r); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(m.closing))
+	return err
+}
+
+func copyRange(w *response.Writer, content io.ReadSeeker, r byteRange) error {
+	if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, content, r.length)
+	return err
+}
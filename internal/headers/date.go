@@ -0,0 +1,26 @@
+package headers
+
+import "time"
+
+// TimeFormat is the IMF-fixdate layout used for HTTP dates such as Date,
+// Expires and Last-Modified (RFC 9110 5.6.7). Times must be in UTC.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// FormatTime formats t as an HTTP date.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseTime parses an HTTP date. Besides IMF-fixdate it accepts the two
+// obsolete formats recipients are required to understand.
+func ParseTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		var t time.Time
+		t, err = time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -5,18 +5,92 @@ import (
 	"strings"
 )
 
-type Headers map[string]string
+// Headers holds header fields in the order they were first added. Each
+// field keeps every value it was given, in order, and the casing of its
+// name as first seen on the wire. Lookups are case-insensitive. The zero
+// value is ready to use, and a nil *Headers reads as empty.
+type Headers struct {
+	fields []*field          // in the order they were added
+	index  map[string]*field // by lowercased name
+}
+
+type field struct {
+	name   string
+	values []string
+}
+
+func NewHeaders() *Headers {
+	return &Headers{index: make(map[string]*field)}
+}
+
+func (h *Headers) lookup(key string) *field {
+	if h == nil {
+		return nil
+	}
+	return h.index[strings.ToLower(key)]
+}
+
+// Get returns the field's values joined with ", ", which RFC 9110 5.3 says
+// is equivalent for list-based fields. Use Values for fields that can't be
+// combined, such as Set-Cookie.
+func (h *Headers) Get(key string) string {
+	f := h.lookup(key)
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ", ")
+}
 
-func NewHeaders() Headers {
-	return make(Headers)
+// Values returns every value of the field, in the order they were added.
+func (h *Headers) Values(key string) []string {
+	f := h.lookup(key)
+	if f == nil {
+		return nil
+	}
+	return f.values
 }
 
-func (h Headers) Get(key string) string {
-	// Lowercase the key for case-insensitive lookup
-	return h[strings.ToLower(key)]
+// Len returns the number of distinct fields.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// Add appends a value to the field, creating it if needed.
+func (h *Headers) Add(key, value string) {
+	if f := h.lookup(key); f != nil {
+		f.values = append(f.values, value)
+		return
+	}
+	h.insert(key, value)
+}
+
+func (h *Headers) insert(key, value string) {
+	if h.index == nil {
+		h.index = make(map[string]*field)
+	}
+	f := &field{name: key, values: []string{value}}
+	h.fields = append(h.fields, f)
+	h.index[strings.ToLower(key)] = f
+}
+
+// Each calls fn for every value of every field, fields in the order they
+// were added and with their original name casing. A field with several
+// values gets one call per value.
+func (h *Headers) Each(fn func(name, value string)) {
+	if h == nil {
+		return
+	}
+	for _, f := range h.fields {
+		for _, value := range f.values {
+			fn(f.name, value)
+		}
+	}
+}
+
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	dataStr := string(data)
 
 	// Look for CRLF
@@ -53,23 +127,32 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 0, false, fmt.Errorf("invalid header: invalid character in key")
 	}
 
-	// Lowercase the key
-	key = strings.ToLower(key)
-
 	// Trim whitespace from value only
 	value = strings.TrimSpace(value)
 
-	// Check if key already exists - append with comma if so
-	if existing, exists := h[key]; exists {
-		h[key] = existing + ", " + value
-	} else {
-		h[key] = value
-	}
+	// Repeated fields keep each value separately
+	h.Add(key, value)
 
 	// Return bytes consumed (line + CRLF)
 	return idx + 2, false, nil
 }
 
+// IsToken reports whether s is a non-empty RFC 9110 token, the syntax of
+// field names, methods and many parameter names.
+func IsToken(s string) bool {
+	return isValidHeaderKey(s)
+}
+
+// HasToken reports whether the comma-separated field value contains token,
+// compared case-insensitively, as in Connection: keep-alive, close.
+func HasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
 
 func isValidHeaderKey(key string) bool {
 	// RFC 9110: token characters
@@ -93,10 +176,26 @@ func isTokenChar(c rune) bool {
 		c == '^' || c == '_' || c == '`' || c == '|' || c == '~'
 }
 
-func (h Headers) Set(key, value string) {
-	h[strings.ToLower(key)] = value
+// Set replaces all values of the field with value. An existing field keeps
+// its position and name casing.
+func (h *Headers) Set(key, value string) {
+	if f := h.lookup(key); f != nil {
+		f.values = []string{value}
+		return
+	}
+	h.insert(key, value)
 }
 
-func (h Headers) Delete(key string) {
-	delete(h, strings.ToLower(key))
+func (h *Headers) Delete(key string) {
+	f := h.lookup(key)
+	if f == nil {
+		return
+	}
+	delete(h.index, strings.ToLower(key))
+	for i, other := range h.fields {
+		if other == f {
+			h.fields = append(h.fields[:i], h.fields[i+1:]...)
+			break
+		}
+	}
 }
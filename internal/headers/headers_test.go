@@ -14,7 +14,7 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -23,7 +23,7 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Host:        localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.False(t, done)
 
 	// Test: Valid header with capital letters
@@ -31,24 +31,24 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Content-Type: application/json\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "application/json", headers["content-type"])
+	assert.Equal(t, "application/json", headers.Get("content-type"))
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
 	headers = NewHeaders()
-	headers["existing"] = "value"
+	headers.Set("existing", "value")
 	data = []byte("Host: localhost:42069\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "value", headers["existing"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
+	assert.Equal(t, "value", headers.Get("existing"))
 	assert.False(t, done)
 
 	// Second header
 	data = []byte("User-Agent: curl/8.5.0\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "curl/8.5.0", headers["user-agent"])
+	assert.Equal(t, "curl/8.5.0", headers.Get("user-agent"))
 	assert.False(t, done)
 
 	// Test: Duplicate header key (append with comma)
@@ -56,14 +56,14 @@ func TestHeadersParse(t *testing.T) {
 	data = []byte("Accept: text/html\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "text/html", headers["accept"])
+	assert.Equal(t, "text/html", headers.Get("accept"))
 	assert.False(t, done)
 
 	// Parse same key again
 	data = []byte("Accept: application/json\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
-	assert.Equal(t, "text/html, application/json", headers["accept"])
+	assert.Equal(t, "text/html, application/json", headers.Get("accept"))
 	assert.False(t, done)
 
 	// Test: Valid done
@@ -90,3 +90,73 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersMultiValue(t *testing.T) {
+	// Test: Repeated fields keep separate values and wire casing
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nX-Custom-ID: 7\r\nset-cookie: b=2\r\n\r\n")
+	total := 0
+	for {
+		n, done, err := headers.Parse(data[total:])
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2"}, headers.Values("set-cookie"))
+	assert.Equal(t, "a=1; Path=/, b=2", headers.Get("Set-Cookie"))
+	assert.Nil(t, headers.Values("missing"))
+
+	var lines []string
+	headers.Each(func(name, value string) {
+		lines = append(lines, name+": "+value)
+	})
+	assert.Equal(t, []string{"Set-Cookie: a=1; Path=/", "Set-Cookie: b=2", "X-Custom-ID: 7"}, lines)
+
+	// Test: Add, Set and Delete keep insertion order deterministic
+	headers = NewHeaders()
+	headers.Set("Content-Type", "text/html")
+	headers.Add("Vary", "Accept-Encoding")
+	headers.Set("Content-Length", "10")
+	headers.Add("vary", "Cookie")
+	headers.Set("content-type", "application/json")
+	headers.Delete("Content-Length")
+	headers.Set("X-After", "1")
+
+	lines = nil
+	headers.Each(func(name, value string) {
+		lines = append(lines, name+": "+value)
+	})
+	assert.Equal(t, []string{
+		"Content-Type: application/json",
+		"Vary: Accept-Encoding",
+		"Vary: Cookie",
+		"X-After: 1",
+	}, lines)
+	assert.Equal(t, 3, headers.Len())
+
+	// Test: A deleted field added again goes to the end
+	headers.Delete("CONTENT-TYPE")
+	headers.Add("Content-Type", "text/plain")
+	lines = nil
+	headers.Each(func(name, value string) {
+		lines = append(lines, name+": "+value)
+	})
+	assert.Equal(t, []string{"Vary: Accept-Encoding", "Vary: Cookie", "X-After: 1", "Content-Type: text/plain"}, lines)
+
+	// Test: A nil *Headers reads as empty
+	var none *Headers
+	assert.Equal(t, "", none.Get("Host"))
+	assert.Nil(t, none.Values("Host"))
+	assert.Equal(t, 0, none.Len())
+	none.Each(func(name, value string) { t.Fatal("nil Headers has fields") })
+}
+
+func TestHasToken(t *testing.T) {
+	assert.True(t, HasToken("close", "close"))
+	assert.True(t, HasToken("keep-alive, Close", "close"))
+	assert.True(t, HasToken("Accept-Encoding,Cookie", "cookie"))
+	assert.False(t, HasToken("closed", "close"))
+	assert.False(t, HasToken("", "close"))
+}
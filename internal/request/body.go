@@ -0,0 +1,134 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errBodyClosed = errors.New("read on closed request body")
+
+// bodyReader streams a request body straight off the connection, decoding
+// Content-Length or chunked framing as it goes.
+type bodyReader struct {
+	parser *Parser
+	req    *Request
+	closed bool
+	err    error
+
+	// onContinue sends 100 Continue; it is called at most once, before the
+	// first read from the connection, if the client is waiting for it
+	onContinue   func() error
+	continueSent bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if err := b.sendContinue(); err != nil {
+		b.err = err
+		return 0, err
+	}
+
+	for len(b.req.pending) == 0 {
+		if b.req.state == stateDone {
+			return 0, io.EOF
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		if err := b.parser.step(b.req); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.req.pending)
+	b.req.pending = b.req.pending[:copy(b.req.pending, b.req.pending[n:])]
+	return n, nil
+}
+
+func (b *bodyReader) sendContinue() error {
+	if b.continueSent || !b.req.expectContinue || b.onContinue == nil || b.req.state == stateDone {
+		return nil
+	}
+	b.continueSent = true
+	return b.onContinue()
+}
+
+// Close stops the handler from reading further. The rest of the body is
+// discarded when the parser moves on to the next request.
+func (b *bodyReader) Close() error {
+	b.closed = true
+	return nil
+}
+
+// discard consumes the rest of the body so the next request can be parsed.
+func (b *bodyReader) discard() error {
+	b.req.pending = b.req.pending[:0]
+	for b.req.state != stateDone {
+		if b.err != nil {
+			return b.err
+		}
+		if err := b.parser.step(b.req); err != nil {
+			return err
+		}
+		b.req.pending = b.req.pending[:0]
+	}
+	return nil
+}
+
+// BodyReader returns the request body as a stream. For requests from
+// NextStream it pulls from the connection; for buffered requests it reads
+// from Body.
+func (r *Request) BodyReader() io.ReadCloser {
+	if r.decoded != nil {
+		return r.decoded
+	}
+	if r.stream != nil {
+		return r.stream
+	}
+	return io.NopCloser(bytes.NewReader(r.Body))
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and is still waiting for the 100 Continue before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.expectContinue && r.stream != nil && !r.stream.continueSent
+}
+
+// OnContinue sets the function that sends 100 Continue. It is called the
+// first time the body is read, if the client is waiting for it, so a
+// handler that rejects the request without reading the body never
+// invites it.
+func (r *Request) OnContinue(fn func() error) {
+	if r.stream != nil {
+		r.stream.onContinue = fn
+	}
+}
+
+// SendContinue sends 100 Continue now rather than on the first read, if
+// the client is waiting for it.
+func (r *Request) SendContinue() error {
+	if r.stream == nil {
+		return nil
+	}
+	return r.stream.sendContinue()
+}
+
+// BufferBody reads the rest of a streamed body into Body, after which the
+// request behaves as if it came from Next.
+func (r *Request) BufferBody() error {
+	if r.stream == nil {
+		return nil
+	}
+	body, err := io.ReadAll(r.BodyReader())
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	r.stream = nil
+	r.decoded = nil
+	return nil
+}
@@ -0,0 +1,150 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DecodeBody undoes the Content-Encoding of the body, so Body and
+// BodyReader give the decoded bytes. gzip (and its alias x-gzip) and
+// deflate are understood, stacked in any order; anything else is
+// ErrUnsupportedEncoding. The decoded body is capped at
+// Limits.MaxDecodedBodyBytes, beyond which it fails with ErrBodyTooLarge,
+// so a small compressed upload can't expand without bound, unless it is
+// NoLimit.
+//
+// A buffered body is decoded straight away; a streamed one as it is read.
+// Content-Encoding and Content-Length are removed from Headers, since they
+// no longer describe the body.
+func (r *Request) DecodeBody() error {
+	codings, err := contentCodings(r.Headers.Get("Content-Encoding"))
+	if err != nil || len(codings) == 0 {
+		return err
+	}
+
+	decoded := &decodedBody{
+		src:       &sourceReader{rc: r.BodyReader()},
+		codings:   codings,
+		limit:     r.limits.MaxDecodedBodyBytes,
+		remaining: r.limits.MaxDecodedBodyBytes,
+	}
+	r.Headers.Delete("Content-Encoding")
+	r.Headers.Delete("Content-Length")
+
+	if r.stream != nil {
+		r.decoded = decoded
+		return nil
+	}
+	body, err := io.ReadAll(decoded)
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+// contentCodings parses a Content-Encoding value into the codings applied,
+// in the order they were applied.
+func contentCodings(value string) ([]string, error) {
+	var codings []string
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			codings = append(codings, "gzip")
+		case "deflate":
+			codings = append(codings, "deflate")
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+	}
+	return codings, nil
+}
+
+// decodedBody reads src through a decoder for each coding, last applied
+// first. The decoders are set up on the first Read, since they read their
+// headers from the connection.
+type decodedBody struct {
+	src       *sourceReader
+	codings   []string
+	r         io.Reader
+	limit     int // NoLimit for none
+	remaining int
+	err       error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.r == nil {
+		d.r = d.src
+		for i := len(d.codings) - 1; i >= 0; i-- {
+			var err error
+			if d.r, err = newDecoder(d.codings[i], d.r); err != nil {
+				d.err = d.classify(err)
+				return 0, d.err
+			}
+		}
+	}
+
+	// Read one byte past the limit to tell a body that exactly fits from
+	// one that doesn't
+	limited := d.limit != NoLimit
+	if limited && len(p) > d.remaining+1 {
+		p = p[:d.remaining+1]
+	}
+	n, err := d.r.Read(p)
+	if limited {
+		if n > d.remaining {
+			d.err = fmt.Errorf("%w: decoded body over %d bytes", ErrBodyTooLarge, d.limit)
+			return 0, d.err
+		}
+		d.remaining -= n
+	}
+	if err != nil && err != io.EOF {
+		d.err = d.classify(err)
+		err = d.err
+	}
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	return d.src.rc.Close()
+}
+
+// classify passes errors from reading the body itself through unchanged,
+// and reports anything else as the encoded data being corrupt.
+func (d *decodedBody) classify(err error) error {
+	if d.src.err != nil && errors.Is(err, d.src.err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+}
+
+func newDecoder(coding string, r io.Reader) (io.Reader, error) {
+	if coding == "gzip" {
+		return gzip.NewReader(r)
+	}
+	return zlib.NewReader(r)
+}
+
+// sourceReader remembers the last error from the encoded body, so it can
+// be told apart from decoding errors.
+type sourceReader struct {
+	rc  io.ReadCloser
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.rc.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,28 @@
+package request
+
+import "errors"
+
+// Errors returned by the parser. They are wrapped with detail about the
+// offending input, so match them with errors.Is. Each one corresponds to a
+// distinct response status, which lets a server tell the client why its
+// request was rejected.
+var (
+	// ErrMalformedRequest means the request isn't valid HTTP/1.1 syntax.
+	ErrMalformedRequest = errors.New("malformed request")
+	// ErrUnsupportedVersion means a well-formed HTTP version other than 1.1.
+	ErrUnsupportedVersion = errors.New("unsupported HTTP version")
+	// ErrNotImplemented means an unknown method or transfer coding.
+	ErrNotImplemented = errors.New("not implemented")
+	// ErrUnsupportedEncoding means a Content-Encoding that DecodeBody
+	// can't undo.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	// ErrExpectationFailed means an Expect header other than
+	// 100-continue.
+	ErrExpectationFailed = errors.New("expectation failed")
+
+	// ErrRequestLineTooLong, ErrHeadersTooLarge and ErrBodyTooLarge mean
+	// the request exceeded its Limits.
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("request headers too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
@@ -0,0 +1,62 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+)
+
+// Errors returned by the form helpers. Unlike the parser errors these come
+// from handlers, which decide how to answer them.
+var (
+	// ErrNotForm means the Content-Type isn't the form type asked for.
+	ErrNotForm = errors.New("request body is not a form")
+	// ErrFormTooLarge means the form's values didn't fit in the memory
+	// allowed for them.
+	ErrFormTooLarge = errors.New("form too large")
+)
+
+const urlencodedType = "application/x-www-form-urlencoded"
+
+// PostForm parses an application/x-www-form-urlencoded body. The result is
+// kept, so later calls return the same values even after a streamed body
+// has been consumed. Query parameters are not included; see URL.Query.
+func (r *Request) PostForm() (Values, error) {
+	if r.postForm != nil {
+		return r.postForm, nil
+	}
+
+	mediaType, _, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != urlencodedType {
+		return nil, fmt.Errorf("%w: content type is %q", ErrNotForm, mediaType)
+	}
+
+	body, err := io.ReadAll(r.BodyReader())
+	if err != nil {
+		return nil, err
+	}
+	values, err := ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+	}
+	r.postForm = values
+	return values, nil
+}
+
+// mediaType parses the Content-Type header into a lowercase media type and
+// its parameters.
+func (r *Request) mediaType() (string, map[string]string, error) {
+	contentType := r.Headers.Get("Content-Type")
+	if contentType == "" {
+		return "", nil, fmt.Errorf("%w: no Content-Type", ErrNotForm)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+	}
+	return mediaType, params, nil
+}
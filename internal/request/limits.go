@@ -0,0 +1,60 @@
+package request
+
+// Limits bounds how much of a request the parser will accept. Zero fields
+// fall back to the matching field of DefaultLimits.
+type Limits struct {
+	// MaxRequestLineBytes caps the request line, excluding its CRLF.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the total size of all header lines, trailers
+	// included.
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header lines, trailers included.
+	MaxHeaderCount int
+	// MaxBodyBytes caps the body size after transfer decoding (chunked).
+	// The default suits buffered bodies; servers taking large streamed
+	// uploads should raise it, or set it to NoLimit.
+	MaxBodyBytes int
+	// MaxDecodedBodyBytes caps the body size after Request.DecodeBody has
+	// undone its Content-Encoding. Like MaxBodyBytes, it may be NoLimit.
+	MaxDecodedBodyBytes int
+}
+
+// NoLimit as Limits.MaxBodyBytes or MaxDecodedBodyBytes accepts a body of
+// any size.
+const NoLimit = -1
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      1 << 20,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+	MaxDecodedBodyBytes: 10 << 20,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes <= 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount <= 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	} else if l.MaxBodyBytes < 0 {
+		l.MaxBodyBytes = NoLimit
+	}
+	if l.MaxDecodedBodyBytes == 0 {
+		l.MaxDecodedBodyBytes = DefaultLimits.MaxDecodedBodyBytes
+	} else if l.MaxDecodedBodyBytes < 0 {
+		l.MaxDecodedBodyBytes = NoLimit
+	}
+	return l
+}
+
+// bodyFits reports whether a body of n bytes is within MaxBodyBytes.
+func (l Limits) bodyFits(n int) bool {
+	return l.MaxBodyBytes == NoLimit || n <= l.MaxBodyBytes
+}
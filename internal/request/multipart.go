@@ -0,0 +1,206 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"sort"
+
+	"httpfromtcp/internal/headers"
+)
+
+// MultipartReader walks the parts of a multipart/form-data body one at a
+// time, straight off the body stream, so file uploads needn't fit in
+// memory.
+type MultipartReader struct {
+	mr *multipart.Reader
+}
+
+// Part is one part of a multipart body. Read returns its content; the
+// previous part is discarded when NextPart is called.
+type Part struct {
+	Headers *headers.Headers
+	// FormName is the name parameter of the Content-Disposition header.
+	FormName string
+	// FileName is the filename parameter with any directories stripped,
+	// or "" if the part isn't a file.
+	FileName string
+
+	p *multipart.Part
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	return p.p.Read(b)
+}
+
+// Close discards the rest of the part's content.
+func (p *Part) Close() error {
+	return p.p.Close()
+}
+
+// MultipartReader returns a reader over a multipart/form-data body. It
+// reads the body from BodyReader, so it shouldn't be mixed with other
+// readers of a streamed body.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := r.mediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/form-data" {
+		return nil, fmt.Errorf("%w: content type is %q", ErrNotForm, mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("%w: multipart/form-data without boundary", ErrMalformedRequest)
+	}
+	return &MultipartReader{mr: multipart.NewReader(r.BodyReader(), boundary)}, nil
+}
+
+// NextPart returns the next part, or io.EOF after the last one.
+func (m *MultipartReader) NextPart() (*Part, error) {
+	p, err := m.mr.NextPart()
+	if err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+		}
+		return nil, err
+	}
+
+	// The part's header map has lost the original order; sort it so the
+	// result is at least stable
+	names := make([]string, 0, len(p.Header))
+	for name := range p.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hdrs := headers.NewHeaders()
+	for _, name := range names {
+		for _, value := range p.Header[name] {
+			hdrs.Add(name, value)
+		}
+	}
+
+	return &Part{Headers: hdrs, FormName: p.FormName(), FileName: p.FileName(), p: p}, nil
+}
+
+// MultipartForm is a fully read multipart/form-data body.
+type MultipartForm struct {
+	Value Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file. Its content is either held in
+// memory or, if it was too large, in a temporary file.
+type FileHeader struct {
+	Filename string
+	Headers  *headers.Headers
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// Open returns the file's content.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes any temporary files behind the form. Handlers that use
+// ParseMultipartForm should defer it.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, fhs := range f.File {
+		for _, fh := range fhs {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm reads a whole multipart/form-data body. Values and
+// file contents share maxMemory bytes of memory: a file that doesn't fit
+// is written to a temporary file instead, while values that don't fit fail
+// with ErrFormTooLarge.
+func (r *Request) ParseMultipartForm(maxMemory int64) (*MultipartForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &MultipartForm{Value: Values{}, File: map[string][]*FileHeader{}}
+	remaining := maxMemory
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		if part.FormName == "" {
+			continue
+		}
+
+		// Read one byte past what fits to tell whether it all did
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, part, remaining+1)
+		if err != nil && err != io.EOF {
+			form.RemoveAll()
+			return nil, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+		}
+
+		if part.FileName == "" {
+			if n > remaining {
+				form.RemoveAll()
+				return nil, fmt.Errorf("%w: value %q doesn't fit in %d bytes", ErrFormTooLarge, part.FormName, maxMemory)
+			}
+			remaining -= n
+			form.Value[part.FormName] = append(form.Value[part.FormName], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: part.FileName, Headers: part.Headers}
+		if n > remaining {
+			fh.tmpfile, fh.Size, err = spill(&buf, part)
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+		} else {
+			remaining -= n
+			fh.content = buf.Bytes()
+			fh.Size = n
+		}
+		form.File[part.FormName] = append(form.File[part.FormName], fh)
+	}
+}
+
+// spill writes what was buffered of a part, then the rest of it, to a new
+// temporary file.
+func spill(buffered io.Reader, rest io.Reader) (string, int64, error) {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := io.Copy(f, io.MultiReader(buffered, rest))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), size, nil
+}
@@ -1,27 +1,68 @@
 package request
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 
+	"httpfromtcp/internal/cookie"
 	"httpfromtcp/internal/headers"
 )
 
 const (
-	stateInitialized      = 0
-	stateParsingHeaders   = 1
-	stateParsingBody      = 2
-	stateDone             = 3
-	bufferSize            = 8
+	stateInitialized        = 0
+	stateParsingHeaders     = 1
+	stateParsingBody        = 2
+	stateDone               = 3
+	stateParsingChunkSize   = 4
+	stateParsingChunkData   = 5
+	stateParsingChunkDataCR = 6
+	stateParsingTrailers    = 7
+	bufferSize              = 8
+
+	// A chunk-size line is a hex number plus optional extensions; anything
+	// this long is not a real one
+	maxChunkLineBytes = 4096
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       int
+	// URL is RequestLine.RequestTarget split into its parts and decoded
+	URL     URL
+	Headers *headers.Headers
+	Body    []byte
+	// Trailers holds fields sent after a chunked body. It is empty for
+	// requests that aren't chunked.
+	Trailers *headers.Headers
+	// RemoteAddr is the client's network address, set by the server.
+	RemoteAddr string
+	state      int
+
+	contentLength  int
+	chunkRemaining int
+
+	// pending holds decoded body bytes not yet handed to the reader, and
+	// bodyReceived counts every decoded body byte so far
+	pending      []byte
+	bodyReceived int
+	stream       *bodyReader
+	// decoded, if set, reads the stream through its Content-Encoding
+	decoded *decodedBody
+	// expectContinue is set when the client waits for 100 Continue
+	// before sending the body
+	expectContinue bool
+
+	limits      Limits
+	headerBytes int
+	headerCount int
+
+	ctx        context.Context
+	pathValues map[string]string
+	// postForm caches the parsed urlencoded body, since a streamed body
+	// can only be read once
+	postForm Values
 }
 
 type RequestLine struct {
@@ -30,60 +71,263 @@ type RequestLine struct {
 	Method        string
 }
 
+// Parser reads successive requests from a single connection. Bytes read
+// past the end of one request are kept and used for the next, so pipelined
+// requests aren't lost.
+type Parser struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+	limits      Limits
+
+	// current is the streamed body of the last request from NextStream;
+	// whatever the handler didn't read is discarded before the next request
+	current *bodyReader
+}
+
+func NewParser(reader io.Reader) *Parser {
+	return NewParserWithLimits(reader, DefaultLimits)
+}
+
+// NewParserWithLimits is like NewParser but rejects requests that exceed
+// limits with ErrRequestLineTooLong, ErrHeadersTooLarge or ErrBodyTooLarge.
+func NewParserWithLimits(reader io.Reader, limits Limits) *Parser {
+	return &Parser{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+		limits: limits.withDefaults(),
+	}
+}
+
+// RequestFromReader parses a single request from reader. Anything after the
+// end of that request is discarded; use a Parser to read more than one.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	return NewParser(reader).Next()
+}
+
+// Next parses the next request on the connection, body included. It returns
+// io.EOF if the connection was closed cleanly before a new request started.
+func (p *Parser) Next() (*Request, error) {
+	req, err := p.NextStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := req.BufferBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// NextStream parses the next request line and headers and returns as soon
+// as they are complete. The body is left on the connection and is read
+// through Request.BodyReader. Calling Next or NextStream again discards
+// whatever part of the body wasn't read.
+func (p *Parser) NextStream() (*Request, error) {
+	if err := p.discardCurrent(); err != nil {
+		return nil, err
+	}
 
 	req := &Request{
-		state:   stateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:    []byte{},
-	}
-
-	for req.state != stateDone {
-		// Grow buffer if full
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
-
-		// Read from reader
-		n, err := reader.Read(buf[readToIndex:])
-		if err == io.EOF {
-			// Check if we were expecting more body data
-			if req.state == stateParsingBody {
-				contentLengthStr := req.Headers.Get("Content-Length")
-				if contentLengthStr != "" {
-					expectedLength, _ := strconv.Atoi(contentLengthStr)
-					if len(req.Body) < expectedLength {
-						return nil, fmt.Errorf("body shorter than reported content length")
-					}
-				}
-			}
-			req.state = stateDone
-			break
-		}
-		if err != nil {
+		state:    stateInitialized,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		limits:   p.limits,
+	}
+
+	for req.state == stateInitialized || req.state == stateParsingHeaders {
+		if err := p.step(req); err != nil {
 			return nil, err
 		}
+	}
 
-		readToIndex += n
+	p.current = &bodyReader{parser: p, req: req}
+	req.stream = p.current
+	return req, nil
+}
 
-		// Parse what we've read so far
-		parsed, err := req.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
+// WaitForRequest blocks until the first bytes of the next request are
+// available, discarding whatever is left of the previous request's body. It
+// returns io.EOF if the connection is closed cleanly first. Servers use it
+// to tell an idle connection apart from one that is sending a request.
+func (p *Parser) WaitForRequest() error {
+	if err := p.discardCurrent(); err != nil {
+		return err
+	}
+	if p.readToIndex > 0 {
+		return nil
+	}
+	n, err := p.read()
+	p.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+func (p *Parser) discardCurrent() error {
+	if p.current == nil {
+		return nil
+	}
+	if err := p.current.discard(); err != nil {
+		return err
+	}
+	p.current = nil
+	return nil
+}
+
+// BodyConsumed reports whether the body of the last request has been read
+// in full, so anything further on the connection belongs to the next
+// request.
+func (p *Parser) BodyConsumed() bool {
+	return p.current == nil || p.current.req.state == stateDone
+}
+
+// ReadAhead does a single read from the connection into the buffer, where
+// the data is kept for the next request. Servers use it to notice a client
+// hanging up while a handler runs; it must only be called once
+// BodyConsumed is true and not concurrently with anything else on p.
+func (p *Parser) ReadAhead() error {
+	n, err := p.read()
+	p.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+// step parses whatever is buffered (which may include leftovers from the
+// previous request) and, if that made no progress, reads more from the
+// connection.
+func (p *Parser) step(req *Request) error {
+	parsed, err := req.parse(p.buf[:p.readToIndex])
+	if err != nil {
+		return err
+	}
+
+	// Remove parsed data from buffer
+	if parsed > 0 {
+		copy(p.buf, p.buf[parsed:p.readToIndex])
+		p.readToIndex -= parsed
+		return nil
+	}
+	if req.state == stateDone {
+		return nil
+	}
+
+	// Read from reader
+	n, err := p.read()
+	p.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+	if err == io.EOF {
+		return req.finishAtEOF(p.readToIndex)
+	}
+	return err
+}
+
+// read fills the free part of the buffer, growing it first if it is full.
+// An EOF that arrives together with data is held back until the data has
+// been parsed.
+func (p *Parser) read() (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	if p.readToIndex >= len(p.buf) {
+		newBuf := make([]byte, len(p.buf)*2)
+		copy(newBuf, p.buf)
+		p.buf = newBuf
+	}
+	n, err := p.reader.Read(p.buf[p.readToIndex:])
+	if err == io.EOF {
+		p.eof = true
+		if n > 0 {
+			err = nil
 		}
+	}
+	return n, err
+}
+
+// Context returns the request's context. Servers cancel it when the client
+// goes away or the request should otherwise be abandoned; it is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
 
-		// Remove parsed data from buffer
-		if parsed > 0 {
-			copy(buf, buf[parsed:readToIndex])
-			readToIndex -= parsed
+// Host returns the host the request is addressed to: the one in an
+// absolute-form target if present, as RFC 9112 3.2.2 requires, otherwise
+// the Host header.
+func (r *Request) Host() string {
+	if r.URL.Host != "" {
+		return r.URL.Host
+	}
+	return r.Headers.Get("Host")
+}
+
+// Cookies parses the cookies sent in the Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	var cookies []*cookie.Cookie
+	for _, value := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(value)...)
+	}
+	return cookies
+}
+
+// Cookie returns the named cookie, or nil if the client didn't send it.
+func (r *Request) Cookie(name string) *cookie.Cookie {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c
 		}
 	}
+	return nil
+}
 
-	return req, nil
+// PathValue returns the value captured for the named wildcard by whatever
+// routed the request, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue records a value captured from the request path, for
+// PathValue to return.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+// finishAtEOF decides what an EOF means for a partially parsed request.
+func (r *Request) finishAtEOF(buffered int) error {
+	switch r.state {
+	case stateInitialized:
+		// Connection closed before a new request started
+		if buffered == 0 {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	case stateParsingBody:
+		// Check if we were expecting more body data
+		if r.bodyReceived < r.contentLength {
+			return fmt.Errorf("body shorter than reported content length: %w", io.ErrUnexpectedEOF)
+		}
+	case stateParsingChunkSize, stateParsingChunkData, stateParsingChunkDataCR, stateParsingTrailers:
+		return fmt.Errorf("chunked body ended early: %w", io.ErrUnexpectedEOF)
+	}
+	r.state = stateDone
+	return nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -112,50 +356,121 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if consumed == 0 {
+			if len(data) > r.limits.MaxRequestLineBytes+2 {
+				return 0, fmt.Errorf("%w: over %d bytes", ErrRequestLineTooLong, r.limits.MaxRequestLineBytes)
+			}
 			// Need more data
 			return 0, nil
 		}
+		if consumed-2 > r.limits.MaxRequestLineBytes {
+			return 0, fmt.Errorf("%w: %d bytes", ErrRequestLineTooLong, consumed-2)
+		}
+		u, err := parseTarget(reqLine.Method, reqLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = reqLine
+		r.URL = u
 		r.state = stateParsingHeaders
 		return consumed, nil
 
 	case stateParsingHeaders:
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+		}
+		if err := r.checkHeaderLimits(n, done, len(data)); err != nil {
 			return 0, err
 		}
 		if done {
-			r.state = stateParsingBody
+			// Two Host fields leave the target ambiguous (RFC 9112 3.2)
+			if len(r.Headers.Values("Host")) > 1 {
+				return 0, fmt.Errorf("%w: repeated Host field", ErrMalformedRequest)
+			}
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
+			if err := r.parseExpect(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
 
 	case stateParsingBody:
-		contentLengthStr := r.Headers.Get("Content-Length")
-		if contentLengthStr == "" {
-			// No content length, done parsing
+		// Take only what belongs to this body; anything after it is the
+		// start of the next request
+		remaining := r.contentLength - r.bodyReceived
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		r.appendBody(data)
+
+		if r.bodyReceived == r.contentLength {
 			r.state = stateDone
-			return 0, nil
 		}
 
-		expectedLength, err := strconv.Atoi(contentLengthStr)
+		return len(data), nil
+
+	case stateParsingChunkSize:
+		idx := strings.Index(string(data), "\r\n")
+		if idx == -1 {
+			if len(data) > maxChunkLineBytes {
+				return 0, fmt.Errorf("%w: chunk size line too long", ErrMalformedRequest)
+			}
+			// Need more data
+			return 0, nil
+		}
+		size, err := parseChunkSize(string(data[:idx]))
 		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length: %s", contentLengthStr)
+			return 0, err
+		}
+		if !r.limits.bodyFits(r.bodyReceived + size) {
+			return 0, fmt.Errorf("%w: over %d bytes", ErrBodyTooLarge, r.limits.MaxBodyBytes)
 		}
+		if size == 0 {
+			// Last chunk; trailers (possibly none) follow
+			r.state = stateParsingTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = stateParsingChunkData
+		}
+		return idx + 2, nil
 
-		// Append all available data to body
-		r.Body = append(r.Body, data...)
+	case stateParsingChunkData:
+		if len(data) > r.chunkRemaining {
+			data = data[:r.chunkRemaining]
+		}
+		r.appendBody(data)
+		r.chunkRemaining -= len(data)
 
-		// Check if we have enough
-		if len(r.Body) > expectedLength {
-			return 0, fmt.Errorf("body longer than reported content length")
+		if r.chunkRemaining == 0 {
+			r.state = stateParsingChunkDataCR
 		}
+		return len(data), nil
 
-		if len(r.Body) == expectedLength {
-			r.state = stateDone
+	case stateParsingChunkDataCR:
+		// Every chunk's data is followed by CRLF
+		if len(data) < 2 {
+			return 0, nil
 		}
+		if data[0] != '\r' || data[1] != '\n' {
+			return 0, fmt.Errorf("%w: missing CRLF after chunk data", ErrMalformedRequest)
+		}
+		r.state = stateParsingChunkSize
+		return 2, nil
 
-		// Consume all the data we were given
-		return len(data), nil
+	case stateParsingTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+		}
+		if err := r.checkHeaderLimits(n, done, len(data)); err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = stateDone
+		}
+		return n, nil
 
 	case stateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
@@ -165,6 +480,123 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// checkHeaderLimits accounts for one call to Headers.Parse that consumed n
+// of the buffered bytes. A line still waiting for its CRLF counts too, so a
+// client can't get around the limit by never finishing a line.
+func (r *Request) checkHeaderLimits(n int, done bool, buffered int) error {
+	if n == 0 {
+		if r.headerBytes+buffered > r.limits.MaxHeaderBytes {
+			return fmt.Errorf("%w: over %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
+		}
+		return nil
+	}
+
+	r.headerBytes += n
+	if r.headerBytes > r.limits.MaxHeaderBytes {
+		return fmt.Errorf("%w: over %d bytes", ErrHeadersTooLarge, r.limits.MaxHeaderBytes)
+	}
+	if !done {
+		r.headerCount++
+		if r.headerCount > r.limits.MaxHeaderCount {
+			return fmt.Errorf("%w: over %d fields", ErrHeadersTooLarge, r.limits.MaxHeaderCount)
+		}
+	}
+	return nil
+}
+
+func (r *Request) appendBody(data []byte) {
+	r.pending = append(r.pending, data...)
+	r.bodyReceived += len(data)
+}
+
+// startBody picks the body framing once headers are complete. Chunked
+// Transfer-Encoding takes precedence; otherwise Content-Length decides, and
+// no Content-Length means no body.
+func (r *Request) startBody() error {
+	transferEncoding := r.Headers.Get("Transfer-Encoding")
+	contentLengthStr := r.Headers.Get("Content-Length")
+
+	if transferEncoding != "" {
+		// Both framings at once is a request smuggling vector (RFC 9112 6.3)
+		if contentLengthStr != "" {
+			return fmt.Errorf("%w: both Transfer-Encoding and Content-Length present", ErrMalformedRequest)
+		}
+		if !strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+			return fmt.Errorf("%w: Transfer-Encoding %s", ErrNotImplemented, transferEncoding)
+		}
+		r.state = stateParsingChunkSize
+		return nil
+	}
+
+	if contentLengthStr == "" {
+		// No content length, done parsing
+		r.state = stateDone
+		return nil
+	}
+
+	// Content-Length is 1*DIGIT; Atoi alone would also take a sign
+	for i := 0; i < len(contentLengthStr); i++ {
+		if !isDigit(contentLengthStr[i]) {
+			return fmt.Errorf("%w: invalid Content-Length %q", ErrMalformedRequest, contentLengthStr)
+		}
+	}
+	expectedLength, err := strconv.Atoi(contentLengthStr)
+	if err != nil {
+		return fmt.Errorf("%w: invalid Content-Length %q", ErrMalformedRequest, contentLengthStr)
+	}
+	if !r.limits.bodyFits(expectedLength) {
+		return fmt.Errorf("%w: Content-Length %d", ErrBodyTooLarge, expectedLength)
+	}
+	r.contentLength = expectedLength
+	if expectedLength == 0 {
+		r.state = stateDone
+	} else {
+		r.state = stateParsingBody
+	}
+	return nil
+}
+
+// parseExpect checks the Expect header. "100-continue" is the only
+// expectation defined (RFC 9110 10.1.1); it only matters if there is a
+// body to wait for.
+func (r *Request) parseExpect() error {
+	for _, value := range r.Headers.Values("Expect") {
+		for _, expectation := range strings.Split(value, ",") {
+			expectation = strings.TrimSpace(expectation)
+			if expectation == "" {
+				continue
+			}
+			if !strings.EqualFold(expectation, "100-continue") {
+				return fmt.Errorf("%w: %q", ErrExpectationFailed, expectation)
+			}
+			r.expectContinue = r.state != stateDone
+		}
+	}
+	return nil
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions.
+func parseChunkSize(line string) (int, error) {
+	if idx := strings.Index(line, ";"); idx != -1 {
+		line = line[:idx]
+	}
+	line = strings.TrimRight(line, " \t")
+	if line == "" {
+		return 0, fmt.Errorf("%w: empty chunk size", ErrMalformedRequest)
+	}
+	// chunk-size is 1*HEXDIG; ParseInt alone would also take a sign
+	for i := 0; i < len(line); i++ {
+		if !isHex(line[i]) {
+			return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedRequest, line)
+		}
+	}
+	size, err := strconv.ParseInt(line, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedRequest, line)
+	}
+	return int(size), nil
+}
+
 func parseRequestLine(httpRequest string) (int, RequestLine, error) {
 	// Find first \r\n
 	idx := strings.Index(httpRequest, "\r\n")
@@ -177,19 +609,25 @@ func parseRequestLine(httpRequest string) (int, RequestLine, error) {
 	parts := strings.Fields(requestLine)
 
 	if len(parts) != 3 {
-		return 0, RequestLine{}, fmt.Errorf("invalid number of parts in request line")
+		return 0, RequestLine{}, fmt.Errorf("%w: invalid number of parts in request line", ErrMalformedRequest)
 	}
 
 	method, target, version := parts[0], parts[1], parts[2]
 
 	// Validate HTTP/1.1
+	if !isValidVersion(version) {
+		return 0, RequestLine{}, fmt.Errorf("%w: invalid version %q", ErrMalformedRequest, version)
+	}
 	if version != "HTTP/1.1" {
-		return 0, RequestLine{}, fmt.Errorf("invalid version: %s", version)
+		return 0, RequestLine{}, fmt.Errorf("%w: %s", ErrUnsupportedVersion, version)
 	}
 
 	// Validate method
 	if !isValidMethod(method) {
-		return 0, RequestLine{}, fmt.Errorf("invalid method: %s", method)
+		return 0, RequestLine{}, fmt.Errorf("%w: invalid method %q", ErrMalformedRequest, method)
+	}
+	if !isKnownMethod(method) {
+		return 0, RequestLine{}, fmt.Errorf("%w: method %s", ErrNotImplemented, method)
 	}
 
 	return idx + 2, RequestLine{
@@ -207,3 +645,24 @@ func isValidMethod(method string) bool {
 	}
 	return true
 }
+
+// isKnownMethod reports whether method is one of the methods defined by
+// RFC 9110 or PATCH (RFC 5789).
+func isKnownMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return true
+	}
+	return false
+}
+
+// isValidVersion reports whether version has the HTTP-version shape
+// "HTTP/" DIGIT "." DIGIT, regardless of whether we support it.
+func isValidVersion(version string) bool {
+	return len(version) == 8 && strings.HasPrefix(version, "HTTP/") &&
+		isDigit(version[5]) && version[6] == '.' && isDigit(version[7])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -1,7 +1,14 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -99,9 +106,9 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
@@ -111,7 +118,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Headers))
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -128,7 +135,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
-	assert.Equal(t, "text/html, application/json", r.Headers["accept"])
+	assert.Equal(t, "text/html, application/json", r.Headers.Get("accept"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -137,7 +144,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
-	assert.Equal(t, "application/json", r.Headers["content-type"])
+	assert.Equal(t, "application/json", r.Headers.Get("content-type"))
 
 	// Test: Missing End of Headers (EOF before \r\n\r\n)
 	reader = &chunkReader{
@@ -147,7 +154,7 @@ func TestRequestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	// Should parse what it can before EOF
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
 }
 
 func TestRequestBodyParse(t *testing.T) {
@@ -214,3 +221,614 @@ func TestRequestBodyParse(t *testing.T) {
 }
 
 
+
+func TestParserPipelined(t *testing.T) {
+	// Test: Several requests arriving back-to-back in the same reads
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"helloGET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"GET /third HTTP/1.1\r\n" +
+			"\r\n",
+		numBytesPerRead: 64,
+	}
+	p := NewParser(reader)
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "", string(r.Body))
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+
+	// Test: Clean EOF between requests
+	_, err = p.Next()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: EOF in the middle of a request line
+	p = NewParser(&chunkReader{data: "GET / HT", numBytesPerRead: 3})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestRequestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n, world\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "abc123", r.Trailers.Get("X-Checksum"))
+
+	// Test: Uppercase hex sizes, no trailers, pipelined request after it
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A\r\n0123456789\r\n" +
+			"0\r\n" +
+			"\r\n" +
+			"GET /next HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	p := NewParser(reader)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(r.Body))
+	assert.Equal(t, 0, r.Trailers.Len())
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Signed chunk sizes, which ParseInt would accept
+	for _, size := range []string{"+3", "-0"} {
+		reader = &chunkReader{
+			data: "POST /upload HTTP/1.1\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				size + "\r\nabc\r\n0\r\n\r\n",
+			numBytesPerRead: 5,
+		}
+		_, err = RequestFromReader(reader)
+		assert.ErrorIs(t, err, ErrMalformedRequest, size)
+	}
+
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: EOF before the terminating chunk
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Transfer-Encoding and Content-Length together
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestParserStreamBody(t *testing.T) {
+	// Test: Body read on demand after headers, Content-Length framing
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world" +
+			"POST /chunked HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"4\r\nwiki\r\n5\r\npedia\r\n0\r\nX-Sum: 9\r\n\r\n" +
+			"POST /skipped HTTP/1.1\r\n" +
+			"Content-Length: 4\r\n" +
+			"\r\n" +
+			"junk" +
+			"GET /last HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	p := NewParser(reader)
+
+	r, err := p.NextStream()
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+	assert.Nil(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Chunked framing, trailers available once the body is read
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "wikipedia", string(body))
+	assert.Equal(t, "9", r.Trailers.Get("X-Sum"))
+
+	// Test: Unread body is discarded before the next request
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	assert.Equal(t, "/skipped", r.RequestLine.RequestTarget)
+	require.NoError(t, r.BodyReader().Close())
+	_, err = r.BodyReader().Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	assert.Equal(t, "/last", r.RequestLine.RequestTarget)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// Test: Truncated streamed body surfaces an error
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial",
+		numBytesPerRead: 4,
+	}
+	r, err = NewParser(reader).NextStream()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.Error(t, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 20,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      2,
+		MaxBodyBytes:        8,
+	}
+
+	// Test: Request line over the limit, even before its CRLF arrives
+	reader := &chunkReader{
+		data:            "GET /a/very/long/path/indeed HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err := NewParserWithLimits(reader, limits).Next()
+	assert.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Too many header lines
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	assert.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: One header line that never ends
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Big: " + string(make([]byte, 100)),
+		numBytesPerRead: 16,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	assert.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length over the limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n123456789",
+		numBytesPerRead: 4,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body growing past the limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n12345\r\n5\r\n67890\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = NewParserWithLimits(reader, Limits{MaxBodyBytes: 8}).Next()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: NoLimit takes a body past the default
+	big := strings.Repeat("x", DefaultLimits.MaxBodyBytes+1)
+	for _, framing := range []string{
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(big), big),
+		fmt.Sprintf("Transfer-Encoding: chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(big), big),
+	} {
+		r, err := NewParserWithLimits(strings.NewReader("POST / HTTP/1.1\r\n"+framing), Limits{MaxBodyBytes: NoLimit}).Next()
+		require.NoError(t, err)
+		assert.Len(t, r.Body, len(big))
+	}
+
+	// Test: Within every limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nA: 1\r\nContent-Length: 8\r\n\r\n12345678",
+		numBytesPerRead: 4,
+	}
+	r, err := NewParserWithLimits(reader, limits).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", string(r.Body))
+}
+
+func TestRequestParseErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want error
+	}{
+		{"GET / HTTP/1.1 extra\r\n\r\n", ErrMalformedRequest},
+		{"get / HTTP/1.1\r\n\r\n", ErrMalformedRequest},
+		{"GET / HTTX/1.1\r\n\r\n", ErrMalformedRequest},
+		{"GET / HTTP/1.1\r\nBad Header: x\r\n\r\n", ErrMalformedRequest},
+		{"GET / HTTP/1.0\r\n\r\n", ErrUnsupportedVersion},
+		{"GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"BREW /pot HTTP/1.1\r\n\r\n", ErrNotImplemented},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", ErrNotImplemented},
+		{"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nContent-Length: +3\r\n\r\nabc", ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nContent-Length: -0\r\n\r\n", ErrMalformedRequest},
+		{"POST / HTTP/1.1\r\nContent-Length: 0x3\r\n\r\nabc", ErrMalformedRequest},
+	}
+	for _, tt := range tests {
+		_, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 4})
+		assert.ErrorIs(t, err, tt.want, tt.data)
+	}
+}
+
+func TestRequestTargetParse(t *testing.T) {
+	// Test: Origin-form with path and query
+	reader := &chunkReader{
+		data:            "GET /video%20clips/caf%C3%A9?x=1&tag=a&tag=b+c&empty= HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, FormOrigin, r.URL.Form)
+	assert.Equal(t, "/video clips/café", r.URL.Path)
+	assert.Equal(t, "/video%20clips/caf%C3%A9", r.URL.RawPath)
+	assert.Equal(t, "x=1&tag=a&tag=b+c&empty=", r.URL.RawQuery)
+	assert.Equal(t, "1", r.URL.Query().Get("x"))
+	assert.Equal(t, []string{"a", "b c"}, r.URL.Query()["tag"])
+	assert.Equal(t, []string{""}, r.URL.Query()["empty"])
+
+	// Test: Plain path has no query
+	r, err = RequestFromReader(&chunkReader{data: "GET /video HTTP/1.1\r\n\r\n", numBytesPerRead: 4})
+	require.NoError(t, err)
+	assert.Equal(t, "/video", r.URL.Path)
+	assert.Equal(t, "", r.URL.RawQuery)
+	assert.Empty(t, r.URL.Query())
+
+	// Test: Absolute-form overrides the Host header
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET http://Example.com:8080?q=1 HTTP/1.1\r\nHost: other\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, FormAbsolute, r.URL.Form)
+	assert.Equal(t, "http", r.URL.Scheme)
+	assert.Equal(t, "Example.com:8080", r.URL.Host)
+	assert.Equal(t, "/", r.URL.Path)
+	assert.Equal(t, "1", r.URL.Query().Get("q"))
+	assert.Equal(t, "Example.com:8080", r.Host())
+
+	// Test: Authority-form for CONNECT
+	r, err = RequestFromReader(&chunkReader{data: "CONNECT example.com:443 HTTP/1.1\r\n\r\n", numBytesPerRead: 4})
+	require.NoError(t, err)
+	assert.Equal(t, FormAuthority, r.URL.Form)
+	assert.Equal(t, "example.com:443", r.URL.Host)
+
+	// Test: Asterisk-form for OPTIONS
+	r, err = RequestFromReader(&chunkReader{data: "OPTIONS * HTTP/1.1\r\n\r\n", numBytesPerRead: 4})
+	require.NoError(t, err)
+	assert.Equal(t, FormAsterisk, r.URL.Form)
+
+	// Test: Invalid targets
+	for _, line := range []string{
+		"GET /bad%zz HTTP/1.1",
+		"GET /bad%2 HTTP/1.1",
+		"GET /?q=%G0 HTTP/1.1",
+		"GET * HTTP/1.1",
+		"CONNECT /path HTTP/1.1",
+		"CONNECT example.com HTTP/1.1",
+		"GET ftp://example.com/ HTTP/1.1",
+		"GET http:///path HTTP/1.1",
+		"GET relative/path HTTP/1.1",
+		"GET /frag#ment HTTP/1.1",
+	} {
+		_, err = RequestFromReader(&chunkReader{data: line + "\r\n\r\n", numBytesPerRead: 4})
+		assert.ErrorIs(t, err, ErrMalformedRequest, line)
+	}
+}
+
+func TestRequestCookies(t *testing.T) {
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nCookie: a=1; b=2\r\nCookie: c=3\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 3)
+	assert.Equal(t, "2", r.Cookie("b").Value)
+	assert.Equal(t, "3", r.Cookie("c").Value)
+	assert.Nil(t, r.Cookie("missing"))
+}
+
+func TestRequestPostForm(t *testing.T) {
+	body := "name=Ada+Lovelace&lang=go&lang=c%2B%2B"
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /submit?q=1 HTTP/1.1\r\n" +
+			"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+			fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + body,
+		numBytesPerRead: 6,
+	})
+	require.NoError(t, err)
+	form, err := r.PostForm()
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", form.Get("name"))
+	assert.Equal(t, []string{"go", "c++"}, form["lang"])
+	assert.Empty(t, form.Get("q"))
+
+	// Test: Wrong content type
+	r, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nContent-Type: text/plain\r\nContent-Length: 3\r\n\r\na=b", numBytesPerRead: 6})
+	require.NoError(t, err)
+	_, err = r.PostForm()
+	assert.ErrorIs(t, err, ErrNotForm)
+}
+
+func multipartRequest(t *testing.T, build func(*multipart.Writer)) *Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	build(mw)
+	require.NoError(t, mw.Close())
+
+	raw := "POST /upload HTTP/1.1\r\n" +
+		"Content-Type: " + mw.FormDataContentType() + "\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n\r\n", body.Len()) + body.String()
+	p := NewParser(&chunkReader{data: raw, numBytesPerRead: 7})
+	r, err := p.NextStream()
+	require.NoError(t, err)
+	return r
+}
+
+func TestRequestMultipartReader(t *testing.T) {
+	r := multipartRequest(t, func(mw *multipart.Writer) {
+		require.NoError(t, mw.WriteField("title", "holiday"))
+		fw, err := mw.CreateFormFile("photo", "../../etc/beach.jpg")
+		require.NoError(t, err)
+		fw.Write([]byte("JPEGDATA"))
+	})
+
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName)
+	assert.Equal(t, "", part.FileName)
+	content, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "holiday", string(content))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "photo", part.FormName)
+	// Directories are stripped from the filename
+	assert.Equal(t, "beach.jpg", part.FileName)
+	assert.Equal(t, "application/octet-stream", part.Headers.Get("content-type"))
+	content, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "JPEGDATA", string(content))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRequestParseMultipartForm(t *testing.T) {
+	large := strings.Repeat("x", 100)
+	build := func(mw *multipart.Writer) {
+		require.NoError(t, mw.WriteField("a", "1"))
+		fw, err := mw.CreateFormFile("small", "small.txt")
+		require.NoError(t, err)
+		fw.Write([]byte("tiny"))
+		fw, err = mw.CreateFormFile("large", "large.txt")
+		require.NoError(t, err)
+		fw.Write([]byte(large))
+	}
+
+	// Test: Small file stays in memory, large one spills to disk
+	form, err := multipartRequest(t, build).ParseMultipartForm(32)
+	require.NoError(t, err)
+	assert.Equal(t, "1", form.Value.Get("a"))
+
+	small := form.File["small"][0]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(4), small.Size)
+	assert.Empty(t, small.tmpfile)
+
+	big := form.File["large"][0]
+	assert.Equal(t, int64(len(large)), big.Size)
+	require.NotEmpty(t, big.tmpfile)
+	f, err := big.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, large, string(content))
+
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(big.tmpfile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: Values that don't fit in memory are an error
+	_, err = multipartRequest(t, func(mw *multipart.Writer) {
+		require.NoError(t, mw.WriteField("a", large))
+	}).ParseMultipartForm(32)
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+}
+
+func gzipped(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func deflated(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.String()
+}
+
+func encodedRequest(encoding, body string) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + body
+}
+
+func TestRequestDecodeBody(t *testing.T) {
+	const payload = `{"agent":"a1","readings":[1,2,3]}`
+
+	// Test: Buffered gzip body is decoded in place
+	r, err := RequestFromReader(&chunkReader{data: encodedRequest("gzip", gzipped(t, payload)), numBytesPerRead: 9})
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	assert.Equal(t, payload, string(r.Body))
+	assert.Empty(t, r.Headers.Get("Content-Encoding"))
+	assert.Empty(t, r.Headers.Get("Content-Length"))
+
+	// Test: Streamed, stacked encodings are decoded as they're read
+	p := NewParser(&chunkReader{data: encodedRequest("deflate, GZIP", gzipped(t, deflated(t, payload))), numBytesPerRead: 9})
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(body))
+
+	// Test: No Content-Encoding leaves the body alone
+	r, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc", numBytesPerRead: 9})
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	assert.Equal(t, "abc", string(r.Body))
+
+	// Test: Unknown encodings are refused
+	r, err = RequestFromReader(&chunkReader{data: encodedRequest("br", "xxxx"), numBytesPerRead: 9})
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DecodeBody(), ErrUnsupportedEncoding)
+
+	// Test: Corrupt data is malformed
+	r, err = RequestFromReader(&chunkReader{data: encodedRequest("gzip", "not gzip at all"), numBytesPerRead: 9})
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DecodeBody(), ErrMalformedRequest)
+
+	// Test: A small body that inflates past the limit is stopped
+	bomb := gzipped(t, strings.Repeat("\x00", 1<<20))
+	p = NewParserWithLimits(&chunkReader{data: encodedRequest("gzip", bomb), numBytesPerRead: 512}, Limits{MaxDecodedBodyBytes: 64 << 10})
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	// The error names the limit, not what was left of it
+	assert.ErrorContains(t, err, "over 65536 bytes")
+
+	p = NewParserWithLimits(&chunkReader{data: encodedRequest("gzip", bomb), numBytesPerRead: 512}, Limits{MaxDecodedBodyBytes: 64 << 10})
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.DecodeBody(), ErrBodyTooLarge)
+
+	// Test: NoLimit lifts the cap, for large streamed uploads
+	big := DefaultLimits.MaxDecodedBodyBytes + 1
+	p = NewParserWithLimits(strings.NewReader(encodedRequest("gzip", gzipped(t, strings.Repeat("a", big)))), Limits{MaxDecodedBodyBytes: NoLimit})
+	r, err = p.NextStream()
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	n, err := io.Copy(io.Discard, r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, int64(big), n)
+
+	// Test: A body exactly at the limit is fine
+	p = NewParserWithLimits(&chunkReader{data: encodedRequest("gzip", gzipped(t, payload)), numBytesPerRead: 9}, Limits{MaxDecodedBodyBytes: len(payload)})
+	r, err = p.Next()
+	require.NoError(t, err)
+	require.NoError(t, r.DecodeBody())
+	assert.Equal(t, payload, string(r.Body))
+}
+
+func TestRequestExpectContinue(t *testing.T) {
+	// Test: The continue hook runs once, on the first body read
+	p := NewParser(&chunkReader{data: "POST / HTTP/1.1\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello", numBytesPerRead: 4})
+	r, err := p.NextStream()
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	calls := 0
+	r.OnContinue(func() error {
+		calls++
+		return nil
+	})
+	assert.Equal(t, 0, calls)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, calls)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Without a body there's nothing to wait for
+	r, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nExpect: 100-continue\r\n\r\n", numBytesPerRead: 4})
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Any other expectation fails
+	_, err = RequestFromReader(&chunkReader{data: "POST / HTTP/1.1\r\nExpect: 200-ok\r\nContent-Length: 1\r\n\r\nx", numBytesPerRead: 4})
+	assert.ErrorIs(t, err, ErrExpectationFailed)
+}
@@ -0,0 +1,200 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TargetForm is which of the four request-target forms of RFC 9112 3.2 a
+// request used.
+type TargetForm int
+
+const (
+	// FormOrigin is the usual "/path?query".
+	FormOrigin TargetForm = 0
+	// FormAbsolute is "http://host/path?query", as sent to proxies.
+	FormAbsolute TargetForm = 1
+	// FormAuthority is "host:port", used only by CONNECT.
+	FormAuthority TargetForm = 2
+	// FormAsterisk is "*", used only by OPTIONS.
+	FormAsterisk TargetForm = 3
+)
+
+// URL is the parsed request-target.
+type URL struct {
+	Form TargetForm
+	// Scheme is set for absolute-form targets only.
+	Scheme string
+	// Host is set for absolute- and authority-form targets.
+	Host string
+	// Path is the percent-decoded path, and RawPath the path as sent.
+	// For asterisk-form both are "*"; for authority-form both are empty.
+	Path    string
+	RawPath string
+	// RawQuery is the query without its leading "?", still encoded.
+	RawQuery string
+
+	query Values
+}
+
+// Values maps query or form keys to every value given for them, in order.
+type Values map[string][]string
+
+// Get returns the first value for key, or "" if there is none.
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Query returns the decoded query parameters. The map is shared; callers
+// must not modify it.
+func (u URL) Query() Values {
+	if u.query == nil {
+		return Values{}
+	}
+	return u.query
+}
+
+// parseTarget splits and validates a request-target. The allowed forms
+// depend on the method: CONNECT takes only authority-form and only OPTIONS
+// may use "*".
+func parseTarget(method, target string) (URL, error) {
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] >= 0x7f || target[i] == '#' {
+			return URL{}, fmt.Errorf("%w: invalid character in request target", ErrMalformedRequest)
+		}
+	}
+
+	switch {
+	case method == "CONNECT":
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return URL{}, fmt.Errorf("%w: CONNECT target must be host:port, got %q", ErrMalformedRequest, target)
+		}
+		return URL{Form: FormAuthority, Host: target}, nil
+
+	case target == "*":
+		if method != "OPTIONS" {
+			return URL{}, fmt.Errorf("%w: \"*\" target is only allowed for OPTIONS", ErrMalformedRequest)
+		}
+		return URL{Form: FormAsterisk, Path: "*", RawPath: "*"}, nil
+
+	case strings.HasPrefix(target, "/"):
+		u := URL{Form: FormOrigin}
+		return u, u.setPathAndQuery(target)
+
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		scheme = strings.ToLower(scheme)
+		if !ok || (scheme != "http" && scheme != "https") {
+			return URL{}, fmt.Errorf("%w: invalid request target %q", ErrMalformedRequest, target)
+		}
+		u := URL{Form: FormAbsolute, Scheme: scheme}
+
+		end := strings.IndexAny(rest, "/?")
+		if end == -1 {
+			end = len(rest)
+		}
+		u.Host = rest[:end]
+		if u.Host == "" {
+			return URL{}, fmt.Errorf("%w: missing host in request target %q", ErrMalformedRequest, target)
+		}
+
+		pathAndQuery := rest[end:]
+		if !strings.HasPrefix(pathAndQuery, "/") {
+			pathAndQuery = "/" + pathAndQuery
+		}
+		return u, u.setPathAndQuery(pathAndQuery)
+	}
+}
+
+func (u *URL) setPathAndQuery(s string) error {
+	u.RawPath, u.RawQuery, _ = strings.Cut(s, "?")
+
+	path, err := PathUnescape(u.RawPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+	}
+	u.Path = path
+
+	query, err := ParseQuery(u.RawQuery)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+	}
+	u.query = query
+	return nil
+}
+
+// ParseQuery parses a URL-encoded query string such as "a=1&b=2&a=3". It
+// is also the format of application/x-www-form-urlencoded bodies.
+func ParseQuery(query string) (Values, error) {
+	values := Values{}
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = append(values[key], value)
+	}
+	return values, nil
+}
+
+// PathUnescape decodes percent-encoding in a path. Unlike in a query, "+"
+// is left alone.
+func PathUnescape(s string) (string, error) {
+	return unescape(s, false)
+}
+
+func unescape(s string, plusAsSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				end := i + 3
+				if end > len(s) {
+					end = len(s)
+				}
+				return "", fmt.Errorf("invalid percent-encoding %q", s[i:end])
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case c == '+' && plusAsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -1,21 +1,16 @@
 package response
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
+	"httpfromtcp/internal/cookie"
 	"httpfromtcp/internal/headers"
 )
 
-type StatusCode int
-
-const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-)
-
 type writerState int
 
 const (
@@ -26,8 +21,38 @@ const (
 )
 
 type Writer struct {
-	w     io.Writer
-	state writerState
+	w          io.Writer
+	state      writerState
+	closeAfter bool
+
+	// What has gone out so far, for middleware to inspect
+	statusCode   StatusCode
+	headers      *headers.Headers
+	bytesWritten int
+
+	// bodyRemaining is how much of the declared Content-Length is still to
+	// be written, or -1 if the body isn't delimited by one
+	bodyRemaining int
+
+	headerHooks []func(StatusCode, *headers.Headers)
+
+	// encoder, if set, transforms the body, which then goes out chunked
+	newEncoder func(io.Writer) BodyEncoder
+	encoder    BodyEncoder
+	// encoded collects the encoder's output, so each flush of the encoder
+	// goes out as one chunk rather than one per internal write
+	encoded *bufio.Writer
+
+	// omitBody discards body writes, for responses to HEAD
+	omitBody bool
+}
+
+// BodyEncoder transforms body bytes on their way out, e.g. compressing
+// them. Flush pushes out what has been encoded so far; Close ends the
+// encoded stream.
+type BodyEncoder interface {
+	io.WriteCloser
+	Flush() error
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -42,88 +67,345 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("WriteStatusLine must be called first")
 	}
 
-	var reasonPhrase string
-	switch statusCode {
-	case StatusOK:
-		reasonPhrase = "OK"
-	case StatusBadRequest:
-		reasonPhrase = "Bad Request"
-	case StatusInternalServerError:
-		reasonPhrase = "Internal Server Error"
-	default:
-		reasonPhrase = ""
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code %d", statusCode)
+	}
+	// Interim responses go through WriteInformational; 101 would need
+	// the connection handed over, which the server doesn't support
+	if statusCode < 200 {
+		return fmt.Errorf("status %d is not a final status; use WriteInformational", statusCode)
 	}
 
-	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase)
+	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase(statusCode))
 	_, err := w.w.Write([]byte(statusLine))
 	if err != nil {
 		return err
 	}
 
+	w.statusCode = statusCode
 	w.state = stateHeaders
 	return nil
 }
 
-func (w *Writer) WriteHeaders(hdrs headers.Headers) error {
+// WriteInformational writes an interim 1xx response, such as 100 Continue
+// or 103 Early Hints with Link headers. Any number may be written before
+// the final status line; they leave the Writer where it was. hdrs may be
+// nil. 101 isn't allowed, since switching protocols ends HTTP/1.1 on the
+// connection.
+func (w *Writer) WriteInformational(statusCode StatusCode, hdrs *headers.Headers) error {
+	if w.state != stateStatusLine {
+		return fmt.Errorf("WriteInformational must be called before WriteStatusLine")
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("invalid informational status code %d", statusCode)
+	}
+	if hdrs == nil {
+		hdrs = headers.NewHeaders()
+	}
+
+	_, err := fmt.Fprintf(w.w, "HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase(statusCode))
+	if err != nil {
+		return err
+	}
+	return writeFields(w.w, hdrs)
+}
+
+func (w *Writer) WriteHeaders(hdrs *headers.Headers) error {
 	if w.state != stateHeaders {
 		return fmt.Errorf("WriteHeaders must be called after WriteStatusLine and before WriteBody")
 	}
 
-	for key, value := range hdrs {
-		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.w.Write([]byte(headerLine))
-		if err != nil {
-			return err
+	// The handler's Content-Length is what it will write, even if an
+	// encoder changes what goes on the wire
+	declaredLength := contentLength(hdrs)
+
+	// Innermost hooks first, so each middleware sees what the layers
+	// inside it produced
+	for i := len(w.headerHooks) - 1; i >= 0; i-- {
+		w.headerHooks[i](w.statusCode, hdrs)
+	}
+
+	// Only a body whose end the handler marks can be encoded, since the
+	// encoded stream has to be closed
+	chunked := headers.HasToken(hdrs.Get("Transfer-Encoding"), "chunked")
+	noBody := bodyless(w.statusCode)
+	if w.newEncoder != nil && !bodyless(w.statusCode) && (declaredLength > 0 || chunked) {
+		hdrs.Delete("Content-Length")
+		if !chunked {
+			hdrs.Set("Transfer-Encoding", "chunked")
 		}
+	} else {
+		w.newEncoder = nil
+		declaredLength = contentLength(hdrs)
+	}
+
+	// A response we can't delimit (no Content-Length, not chunked) is
+	// terminated by closing the connection. Responses that never have a
+	// body need no delimiting.
+	if headers.HasToken(hdrs.Get("Connection"), "close") ||
+		(!noBody && !w.omitBody && hdrs.Get("Content-Length") == "" && !headers.HasToken(hdrs.Get("Transfer-Encoding"), "chunked")) {
+		w.closeAfter = true
+	}
+	if w.closeAfter {
+		hdrs.Set("connection", "close")
 	}
 
-	// Blank line to end headers
-	_, err := w.w.Write([]byte("\r\n"))
+	// Fields go out in the order they were added, one line per value, and
+	// a blank line ends the headers
+	err := writeFields(w.w, hdrs)
 	if err != nil {
 		return err
 	}
 
+	w.bodyRemaining = declaredLength
+	if noBody {
+		w.bodyRemaining = 0
+	}
+	// Without a body there's nothing to encode, but the headers still
+	// say what a GET would have got
+	if w.newEncoder != nil && !w.omitBody {
+		w.encoded = bufio.NewWriter(chunkWriter{w})
+		w.encoder = w.newEncoder(w.encoded)
+	}
+
+	w.headers = hdrs
 	w.state = stateBody
 	return nil
 }
 
+// WriteBody writes body bytes. It may be called several times; once the
+// declared Content-Length has been written the response is finished and
+// further writes fail. A close-delimited body is never finished, which is
+// fine, since the connection is closed after it.
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != stateBody {
 		return 0, fmt.Errorf("WriteBody must be called after WriteHeaders")
 	}
+	if w.bodyRemaining >= 0 && len(p) > w.bodyRemaining {
+		return 0, fmt.Errorf("WriteBody: %d bytes exceeds the remaining Content-Length of %d", len(p), w.bodyRemaining)
+	}
 
-	n, err := w.w.Write(p)
+	if w.encoder != nil {
+		return w.writeEncoded(p)
+	}
+
+	var n int
+	var err error
+	if w.omitBody {
+		n = len(p)
+	} else {
+		n, err = w.w.Write(p)
+		w.bytesWritten += n
+	}
+	if w.bodyRemaining >= 0 {
+		w.bodyRemaining -= n
+	}
 	if err != nil {
 		return n, err
 	}
 
-	w.state = stateDone
+	if w.bodyRemaining == 0 {
+		w.state = stateDone
+	}
+	return n, nil
+}
+
+// writeEncoded is WriteBody through the encoder. The encoded stream, and
+// the chunked body carrying it, end when the handler's Content-Length has
+// been written.
+func (w *Writer) writeEncoded(p []byte) (int, error) {
+	n, err := w.encoder.Write(p)
+	if w.bodyRemaining >= 0 {
+		w.bodyRemaining -= n
+	}
+	if err != nil {
+		return n, err
+	}
+
+	if w.bodyRemaining == 0 {
+		err = w.closeEncoder()
+		if err != nil {
+			return n, err
+		}
+		_, err = io.WriteString(w.w, "0\r\n\r\n")
+		if err != nil {
+			return n, err
+		}
+		w.state = stateDone
+	}
 	return n, nil
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+// Write is WriteBody, so that a Writer can be the destination of io.Copy.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// OmitBody makes the Writer send the status line and headers but discard
+// the body, as the response to a HEAD request must. Handlers write their
+// responses as for GET, so Content-Length and the other headers describe
+// the body that would have been sent. It must be called before the headers
+// are written; the server does so for HEAD requests.
+func (w *Writer) OmitBody() {
+	w.omitBody = true
+}
+
+// SetBodyEncoder makes the body go out through the encoder newEncoder
+// returns, which is handed the wire. It must be called before the headers
+// are written, typically from an OnWriteHeaders hook. It is ignored for
+// responses with no body, or with neither a Content-Length nor chunked
+// encoding to mark where the body ends. The Writer takes care of framing:
+// it drops Content-Length and sends the body chunked. Content-Encoding is
+// up to the caller.
+func (w *Writer) SetBodyEncoder(newEncoder func(io.Writer) BodyEncoder) {
+	w.newEncoder = newEncoder
+}
+
+// OnWriteHeaders registers fn to run just before the headers are written,
+// with the status code and the headers the handler passed in. fn may modify
+// the headers. Hooks registered later (by middleware closer to the handler)
+// run first.
+func (w *Writer) OnWriteHeaders(fn func(statusCode StatusCode, h *headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// StatusCode returns the status written so far, or 0 if none has been.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// Headers returns the headers as written, or nil if they haven't been.
+func (w *Writer) Headers() *headers.Headers {
+	return w.headers
+}
+
+// BytesWritten returns how many body bytes have been written, not counting
+// chunked framing or trailers.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// CloseAfterResponse marks the connection to be closed once this response
+// has been written. It must be called before WriteHeaders to take effect on
+// the wire, where it adds "Connection: close".
+func (w *Writer) CloseAfterResponse() {
+	w.closeAfter = true
+}
+
+// WillClose reports whether the connection must be closed after this
+// response, either because the server asked for it or because the headers
+// written by the handler require it.
+func (w *Writer) WillClose() bool {
+	return w.closeAfter
+}
+
+// Started reports whether any part of the response (the status line) has
+// been written.
+func (w *Writer) Started() bool {
+	return w.state != stateStatusLine
+}
+
+// Finished reports whether a complete response has been written. An empty
+// Content-Length body is complete as soon as the headers are.
+func (w *Writer) Finished() bool {
+	return w.state == stateDone || (w.state == stateBody && w.bodyRemaining == 0 && w.encoder == nil)
+}
+
+// WriteError writes a complete plain-text response with message as the
+// body. The connection is closed afterwards, since after an error the
+// server can't trust where the next request starts.
+func (w *Writer) WriteError(statusCode StatusCode, message string) error {
+	w.CloseAfterResponse()
+	return w.WriteText(statusCode, message+"\n", nil)
+}
+
+// WriteText writes a complete response with body as its content, typed
+// text/plain unless extra says otherwise. extra, which may be nil, holds
+// any further header fields, such as Location or Allow; they go out after
+// the defaults, in the order they were added.
+func (w *Writer) WriteText(statusCode StatusCode, body string, extra *headers.Headers) error {
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+
+	hdrs := GetDefaultHeaders(len(body))
+	if extra != nil {
+		// A field in extra replaces the default of the same name
+		replaced := map[string]bool{}
+		extra.Each(func(name, value string) {
+			if lower := strings.ToLower(name); !replaced[lower] {
+				hdrs.Delete(name)
+				replaced[lower] = true
+			}
+			hdrs.Add(name, value)
+		})
+	}
+	err = w.WriteHeaders(hdrs)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteBody([]byte(body))
+	return err
+}
+
+// SetCookie adds a Set-Cookie line for c to hdrs. Each cookie gets its
+// own line; they are never comma-joined.
+func SetCookie(hdrs *headers.Headers, c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	hdrs.Add("Set-Cookie", c.String())
+	return nil
+}
+
+func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
-	h["content-length"] = strconv.Itoa(contentLen)
-	h["connection"] = "close"
-	h["content-type"] = "text/plain"
+	h.Set("content-length", strconv.Itoa(contentLen))
+	h.Set("content-type", "text/plain")
 	return h
 }
 
+// contentLength returns the Content-Length in hdrs, or -1 if there is no
+// valid one.
+func contentLength(hdrs *headers.Headers) int {
+	n, err := strconv.Atoi(hdrs.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// bodyless reports whether a response with statusCode never has a body,
+// whatever its headers say (RFC 9112 6.3).
+func bodyless(statusCode StatusCode) bool {
+	return (statusCode >= 100 && statusCode < 200) || statusCode == StatusNoContent || statusCode == StatusNotModified
+}
+
 // Keep old functions for compatibility (optional)
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
 	writer := NewWriter(w)
 	return writer.WriteStatusLine(statusCode)
 }
 
-func WriteHeaders(w io.Writer, hdrs headers.Headers) error {
-	for key, value := range hdrs {
-		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.Write([]byte(headerLine))
-		if err != nil {
-			return err
-		}
-	}
-	_, err := w.Write([]byte("\r\n"))
+func WriteHeaders(w io.Writer, hdrs *headers.Headers) error {
+	return writeFields(w, hdrs)
+}
+
+// writeFields writes each field line followed by the terminating blank
+// line, in a single Write.
+func writeFields(w io.Writer, hdrs *headers.Headers) error {
+	var b strings.Builder
+	hdrs.Each(func(name, value string) {
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteString("\r\n")
+	})
+	b.WriteString("\r\n")
+
+	_, err := io.WriteString(w, b.String())
 	return err
 }
 
@@ -136,7 +418,29 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if w.omitBody {
+		return len(p), nil
+	}
+
+	// Flush each chunk through the encoder, so a streamed body still
+	// streams
+	if w.encoder != nil {
+		n, err := w.encoder.Write(p)
+		if err != nil {
+			return n, err
+		}
+		err = w.encoder.Flush()
+		if err != nil {
+			return n, err
+		}
+		return n, w.encoded.Flush()
+	}
+
+	return w.writeChunk(p)
+}
 
+// writeChunk writes p as one chunk on the wire.
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	// Write chunk size in hex
 	chunkSize := fmt.Sprintf("%x\r\n", len(p))
 	_, err := w.w.Write([]byte(chunkSize))
@@ -146,6 +450,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 	// Write chunk data
 	n, err := w.w.Write(p)
+	w.bytesWritten += n
 	if err != nil {
 		return n, err
 	}
@@ -163,6 +468,16 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.state != stateBody {
 		return 0, fmt.Errorf("WriteChunkedBodyDone must be called after WriteHeaders")
 	}
+	if w.omitBody {
+		return 0, nil
+	}
+
+	if w.encoder != nil {
+		err := w.closeEncoder()
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	// Write final chunk size (0) - NO final blank line yet
 	finalChunk := "0\r\n"
@@ -175,22 +490,19 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	return n, nil
 }
 
-func (w *Writer) WriteTrailers(hdrs headers.Headers) error {
+func (w *Writer) WriteTrailers(hdrs *headers.Headers) error {
 	if w.state != stateBody {
 		return fmt.Errorf("WriteTrailers must be called after chunked body")
 	}
-
-	// Trailers are just headers after the 0\r\n
-	for key, value := range hdrs {
-		trailerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.w.Write([]byte(trailerLine))
-		if err != nil {
-			return err
-		}
+	// Trailers are part of the body
+	if w.omitBody {
+		w.state = stateDone
+		return nil
 	}
 
-	// Final blank line ends the response
-	_, err := w.w.Write([]byte("\r\n"))
+	// Trailers are just headers after the 0\r\n; the final blank line
+	// ends the response
+	err := writeFields(w.w, hdrs)
 	if err != nil {
 		return err
 	}
@@ -199,3 +511,23 @@ func (w *Writer) WriteTrailers(hdrs headers.Headers) error {
 	return nil
 }
 
+// closeEncoder ends the encoded stream and sends what is left of it.
+func (w *Writer) closeEncoder() error {
+	err := w.encoder.Close()
+	if err != nil {
+		return err
+	}
+	return w.encoded.Flush()
+}
+
+// chunkWriter frames whatever an encoder produces as chunks.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return c.w.writeChunk(p)
+}
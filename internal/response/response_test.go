@@ -0,0 +1,154 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	tests := []struct {
+		code StatusCode
+		want string
+	}{
+		{StatusOK, "HTTP/1.1 200 OK\r\n"},
+		{StatusNotFound, "HTTP/1.1 404 Not Found\r\n"},
+		{StatusContentTooLarge, "HTTP/1.1 413 Content Too Large\r\n"},
+		{StatusTooManyRequests, "HTTP/1.1 429 Too Many Requests\r\n"},
+		{StatusNetworkAuthenticationRequired, "HTTP/1.1 511 Network Authentication Required\r\n"},
+		// Unregistered codes get their class name
+		{299, "HTTP/1.1 299 Successful\r\n"},
+		{418, "HTTP/1.1 418 Client Error\r\n"},
+		{599, "HTTP/1.1 599 Server Error\r\n"},
+		// Beyond 5xx there's no class, and the reason phrase is empty
+		{999, "HTTP/1.1 999 \r\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		require.NoError(t, NewWriter(&out).WriteStatusLine(tt.code))
+		assert.Equal(t, tt.want, out.String())
+	}
+
+	// Test: Codes that aren't three digits are rejected without writing
+	for _, code := range []StatusCode{0, 99, 1000, -200} {
+		var out bytes.Buffer
+		w := NewWriter(&out)
+		assert.Error(t, w.WriteStatusLine(code), code)
+		assert.Empty(t, out.String())
+		assert.False(t, w.Started())
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Early Hints", StatusText(StatusEarlyHints))
+	assert.Equal(t, "Permanent Redirect", StatusText(StatusPermanentRedirect))
+	assert.Equal(t, "Unprocessable Content", StatusText(StatusUnprocessableContent))
+	assert.Equal(t, "", StatusText(299))
+}
+
+func TestWriteInformational(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+
+	hints := headers.NewHeaders()
+	hints.Add("Link", "</style.css>; rel=preload; as=style")
+	hints.Add("Link", "</app.js>; rel=preload; as=script")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+	assert.False(t, w.Started())
+
+	// Test: Only 1xx codes other than 101, and only before the final status
+	assert.Error(t, w.WriteInformational(StatusOK, nil))
+	assert.Error(t, w.WriteInformational(StatusSwitchingProtocols, nil))
+	assert.Error(t, w.WriteStatusLine(StatusContinue))
+
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Error(t, w.WriteInformational(StatusEarlyHints, nil))
+
+	br := bufio.NewReader(&out)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 103, resp.StatusCode)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script"}, resp.Header.Values("Link"))
+
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestOmitBody(t *testing.T) {
+	// Test: A fixed-length body is counted but not written
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(11)))
+	n, err := w.WriteBody([]byte("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, n)
+	assert.True(t, w.Finished())
+	assert.False(t, w.WillClose())
+	assert.Equal(t, 0, w.BytesWritten())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 11\r\ncontent-type: text/plain\r\n\r\n", out.String())
+
+	// Test: Too much body is still an error
+	_, err = w.WriteBody([]byte("!"))
+	assert.Error(t, err)
+
+	// Test: Chunks and trailers are dropped, leaving only the headers
+	out.Reset()
+	w = NewWriter(&out)
+	w.OmitBody()
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("chunk"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "1")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, w.Finished())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n", out.String())
+}
+
+func TestWriteText(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	extra := headers.NewHeaders()
+	extra.Set("Location", "/docs/")
+	extra.Set("Cache-Control", "no-cache")
+	extra.Set("Content-Type", "text/plain; charset=utf-8")
+	extra.Set("X-Request-Id", "42")
+	require.NoError(t, w.WriteText(StatusMovedPermanently, "Moved Permanently\n", extra))
+	assert.True(t, w.Finished())
+
+	// Test: Extra fields follow the defaults in the order given, replacing
+	// a default of the same name
+	var names []string
+	w.Headers().Each(func(name, value string) { names = append(names, name) })
+	assert.Equal(t, []string{"content-length", "Location", "Cache-Control", "Content-Type", "X-Request-Id"}, names)
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/docs/", resp.Header.Get("Location"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Moved Permanently\n", string(body))
+}
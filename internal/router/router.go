@@ -0,0 +1,227 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Router dispatches requests to handlers by method, host and path.
+//
+// Patterns look like "/users/{id}" or "example.com/static/*". An optional
+// host comes before the first slash. Path segments are either literal,
+// "{name}" to capture one segment, or a final "*" to capture the rest of the
+// path. Captured values are available through Request.PathValue, with the
+// rest-of-path capture under "*".
+//
+// When several patterns match, the most specific one wins: a host beats no
+// host, and segment by segment a literal beats a capture, which beats "*".
+//
+// GET routes also answer HEAD, unless a HEAD route for the same pattern is
+// registered; the server leaves the body off.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	method   string // "" matches any method
+	host     string // "" matches any host
+	segments []segment
+	rest     bool // pattern ends in "*"
+	handler  server.Handler
+}
+
+type segment struct {
+	literal string
+	param   string // set for "{name}" segments
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method and pattern. An empty method matches
+// any method. It panics if pattern is malformed, since that is a programming
+// error.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	r, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: %v", err))
+	}
+	r.method = method
+	r.handler = handler
+	rt.routes = append(rt.routes, r)
+}
+
+// ServeRequest is a server.Handler that dispatches to the registered
+// routes. It answers 404 if no pattern matches the path, and 405 with an
+// Allow header if patterns match but none for this method.
+func (rt *Router) ServeRequest(req *request.Request, w *response.Writer) error {
+	// "*" and authority-form targets have no path to route on
+	if req.URL.Form != request.FormOrigin && req.URL.Form != request.FormAbsolute {
+		return w.WriteText(response.StatusNotFound, "Not Found\n", nil)
+	}
+	host := hostOnly(req.Host())
+	pathSegments := splitPath(req.URL.RawPath)
+
+	var best *route
+	var bestValues map[string]string
+	allowed := map[string]bool{}
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		values, ok := r.match(host, pathSegments)
+		if !ok {
+			continue
+		}
+		if !r.allows(req.RequestLine.Method) {
+			allowed[r.method] = true
+			if r.method == "GET" {
+				allowed["HEAD"] = true
+			}
+			continue
+		}
+		if best == nil || r.moreSpecific(best) {
+			best, bestValues = r, values
+		}
+	}
+
+	if best == nil {
+		if len(allowed) > 0 {
+			return writeMethodNotAllowed(w, allowed)
+		}
+		return w.WriteText(response.StatusNotFound, "Not Found\n", nil)
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+	return best.handler(req, w)
+}
+
+func parsePattern(pattern string) (route, error) {
+	var r route
+	idx := strings.Index(pattern, "/")
+	if idx == -1 {
+		return r, fmt.Errorf("pattern %q has no path", pattern)
+	}
+	r.host = strings.ToLower(pattern[:idx])
+
+	parts := strings.Split(pattern[idx+1:], "/")
+	seen := map[string]bool{}
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				return r, fmt.Errorf("pattern %q: \"*\" must be the last segment", pattern)
+			}
+			r.rest = true
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" || seen[name] {
+				return r, fmt.Errorf("pattern %q: empty or duplicate name %q", pattern, name)
+			}
+			seen[name] = true
+			r.segments = append(r.segments, segment{param: name})
+		case strings.ContainsAny(part, "{}*"):
+			return r, fmt.Errorf("pattern %q: invalid segment %q", pattern, part)
+		default:
+			r.segments = append(r.segments, segment{literal: part})
+		}
+	}
+	return r, nil
+}
+
+// match reports whether the route matches host and the path segments, and
+// returns the captured values.
+func (r *route) match(host string, path []string) (map[string]string, bool) {
+	if r.host != "" && r.host != host {
+		return nil, false
+	}
+	if len(path) < len(r.segments) || (!r.rest && len(path) != len(r.segments)) {
+		return nil, false
+	}
+
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.param != "" {
+			if path[i] == "" {
+				return nil, false
+			}
+			values[seg.param] = path[i]
+		} else if seg.literal != path[i] {
+			return nil, false
+		}
+	}
+	if r.rest {
+		values["*"] = strings.Join(path[len(r.segments):], "/")
+	}
+	return values, true
+}
+
+// moreSpecific reports whether r should win over other when both match.
+func (r *route) moreSpecific(other *route) bool {
+	if (r.host != "") != (other.host != "") {
+		return r.host != ""
+	}
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		a, b := r.segments[i].param == "", other.segments[i].param == ""
+		if a != b {
+			return a
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+	if r.rest != other.rest {
+		return !r.rest
+	}
+	// Same shape: a route for this exact method beats a catch-all, and a
+	// HEAD route beats the GET one standing in for it
+	if r.method == "HEAD" && other.method == "GET" {
+		return true
+	}
+	return r.method != "" && other.method == ""
+}
+
+// allows reports whether the route handles method.
+func (r *route) allows(method string) bool {
+	return r.method == "" || r.method == method || (r.method == "GET" && method == "HEAD")
+}
+
+// splitPath splits an encoded path into decoded segments. Splitting before
+// decoding keeps an encoded "%2F" inside its segment.
+func splitPath(rawPath string) []string {
+	segments := strings.Split(strings.TrimPrefix(rawPath, "/"), "/")
+	for i, seg := range segments {
+		// The parser has already rejected bad escapes
+		if decoded, err := request.PathUnescape(seg); err == nil {
+			segments[i] = decoded
+		}
+	}
+	return segments
+}
+
+// hostOnly strips any port from a Host header value and lowercases it.
+func hostOnly(host string) string {
+	if idx := strings.LastIndex(host, ":"); idx != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:idx]
+	}
+	return strings.ToLower(host)
+}
+
+func writeMethodNotAllowed(w *response.Writer, allowed map[string]bool) error {
+	methods := make([]string, 0, len(allowed))
+	for method := range allowed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	extra := headers.NewHeaders()
+	extra.Set("allow", strings.Join(methods, ", "))
+	return w.WriteText(response.StatusMethodNotAllowed, "Method Not Allowed\n", extra)
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// named returns a handler that answers with its name plus any captured
+// path values, so tests can see which route won.
+func named(name string, params ...string) func(*request.Request, *response.Writer) error {
+	return func(req *request.Request, w *response.Writer) error {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		return w.WriteText(response.StatusOK, body, nil)
+	}
+}
+
+func serve(t *testing.T, rt *Router, raw string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, rt.ServeRequest(req, response.NewWriter(&out)))
+
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestRouterMatching(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", named("root"))
+	rt.Handle("GET", "/users/{id}", named("user", "id"))
+	rt.Handle("GET", "/users/me", named("me"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", named("post", "id", "post"))
+	rt.Handle("GET", "/static/*", named("static", "*"))
+	rt.Handle("GET", "api.example.com/users/{id}", named("api-user", "id"))
+	rt.Handle("", "/any", named("any"))
+	rt.Handle("HEAD", "/users/me", named("me-head"))
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", "root"},
+		{"GET /users/42 HTTP/1.1\r\n\r\n", "user id=42"},
+		{"GET /users/42?x=1 HTTP/1.1\r\n\r\n", "user id=42"},
+		// Literal segments beat captures
+		{"GET /users/me HTTP/1.1\r\n\r\n", "me"},
+		{"GET /users/7/posts/9 HTTP/1.1\r\n\r\n", "post id=7 post=9"},
+		{"GET /static/css/site.css HTTP/1.1\r\n\r\n", "static *=css/site.css"},
+		// Host patterns beat host-less ones, ignoring port and case
+		{"GET /users/42 HTTP/1.1\r\nHost: API.example.com:8080\r\n\r\n", "api-user id=42"},
+		{"GET /users/42 HTTP/1.1\r\nHost: other.example.com\r\n\r\n", "user id=42"},
+		{"DELETE /any HTTP/1.1\r\n\r\n", "any"},
+		// GET routes answer HEAD, unless there's a HEAD route
+		{"HEAD /users/42 HTTP/1.1\r\n\r\n", "user id=42"},
+		{"HEAD /users/me HTTP/1.1\r\n\r\n", "me-head"},
+		// Captures are decoded, but an encoded slash stays in its segment
+		{"GET /users/a%2Fb HTTP/1.1\r\n\r\n", "user id=a/b"},
+		// Absolute-form targets route on their path and host
+		{"GET http://api.example.com/users/5 HTTP/1.1\r\n\r\n", "api-user id=5"},
+	}
+	for _, tt := range tests {
+		resp, body := serve(t, rt, tt.raw)
+		assert.Equal(t, 200, resp.StatusCode, tt.raw)
+		assert.Equal(t, tt.want, body, tt.raw)
+	}
+}
+
+func TestRouterNotFoundAndMethodNotAllowed(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/items/{id}", named("get"))
+	rt.Handle("PUT", "/items/{id}", named("put"))
+	rt.Handle("DELETE", "/items/{id}", named("delete"))
+
+	// Test: No pattern matches the path
+	resp, _ := serve(t, rt, "GET /nothing HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: A capture doesn't match an empty segment
+	resp, _ = serve(t, rt, "GET /items/ HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: Path matches, method doesn't
+	resp, _ = serve(t, rt, "POST /items/1 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, PUT", resp.Header.Get("Allow"))
+}
+
+func TestRouterBadPatterns(t *testing.T) {
+	for _, pattern := range []string{"users", "/a/*/b", "/{}", "/{id}/{id}", "/a{b}"} {
+		assert.Panics(t, func() { New().Handle("GET", pattern, named("x")) }, pattern)
+	}
+}
@@ -1,24 +1,156 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 )
 
+const (
+	defaultIdleTimeout        = 60 * time.Second
+	defaultReadHeaderTimeout  = 10 * time.Second
+	defaultMaxRequestsPerConn = 1000
+
+	// How often Shutdown checks whether active connections have finished
+	shutdownPollInterval = 10 * time.Millisecond
+)
+
+// Config tunes how the server treats each connection. Zero values fall back
+// to sensible defaults.
+type Config struct {
+	// IdleTimeout is how long a kept-alive connection may wait for the next
+	// request before it is closed.
+	IdleTimeout time.Duration
+	// ReadHeaderTimeout bounds reading the request line and headers, from
+	// the first byte of the request. Clients that are too slow get a 408.
+	ReadHeaderTimeout time.Duration
+	// ReadBodyTimeout bounds reading the request body, whether it is
+	// buffered up front or streamed by the handler. Zero means no limit.
+	ReadBodyTimeout time.Duration
+	// WriteTimeout bounds writing the response, from the moment the handler
+	// is called. Zero means no limit.
+	WriteTimeout time.Duration
+	// HandlerTimeout cancels the request's context after this long. The
+	// handler is expected to notice and return. Zero means no limit.
+	HandlerTimeout time.Duration
+	// MaxRequestsPerConn caps how many requests are served on a single
+	// connection before it is closed.
+	MaxRequestsPerConn int
+	// StreamBodies hands requests to the handler as soon as their headers
+	// are parsed, with the body read on demand through
+	// Request.BodyReader. By default the whole body is buffered into
+	// Request.Body first. Limits.MaxBodyBytes still applies, and its
+	// default is sized for buffering: raise it, or set it to
+	// request.NoLimit, to take large uploads.
+	StreamBodies bool
+	// DecodeRequestBodies undoes gzip and deflate Content-Encoding on
+	// request bodies before the handler sees them (see
+	// Request.DecodeBody). Other encodings are answered with 415, and
+	// bodies that decode past Limits.MaxDecodedBodyBytes with 413.
+	DecodeRequestBodies bool
+	// AutoContinue sends 100 Continue as soon as the headers of a request
+	// with "Expect: 100-continue" are parsed. By default it is sent when
+	// the body is first read, so a handler can refuse the request before
+	// the client uploads anything. Other expectations get a 417 either way.
+	AutoContinue bool
+	// Limits bounds request line, header and body sizes. Requests over a
+	// limit are answered with 414, 431 or 413.
+	Limits request.Limits
+	// ErrorLog receives errors from accepting connections, parsing
+	// requests and handlers. It defaults to slog.Default().
+	ErrorLog *slog.Logger
+	// OnRejected, if set, is called after the server has answered a
+	// request it couldn't parse (400, 408, 413 and so on), which never
+	// reaches the handler. w holds the response that was sent.
+	OnRejected func(remoteAddr string, w *response.Writer)
+}
+
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+func (c Config) readHeaderTimeout() time.Duration {
+	if c.ReadHeaderTimeout > 0 {
+		return c.ReadHeaderTimeout
+	}
+	return defaultReadHeaderTimeout
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.cfg.ErrorLog != nil {
+		return s.cfg.ErrorLog
+	}
+	return slog.Default()
+}
+
+func (c Config) maxRequestsPerConn() int {
+	if c.MaxRequestsPerConn > 0 {
+		return c.MaxRequestsPerConn
+	}
+	return defaultMaxRequestsPerConn
+}
+
 type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	handler  Handler
+	cfg      Config
+
+	// baseCtx is the parent of every request context; it is cancelled when
+	// Shutdown gives up waiting and cuts connections off
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+
+	// conns tracks open connections and whether each is idle (waiting for
+	// a request) or active, so Shutdown knows which it may close
+	mu    sync.Mutex
+	conns map[net.Conn]connState
 }
 
+type connState int
+
+const (
+	connIdle   connState = 0
+	connActive connState = 1
+)
+
 // Handler now takes response.Writer instead of io.Writer
 type Handler func(req *request.Request, w *response.Writer) error
 
+// Middleware wraps a Handler with behaviour that runs around it, such as
+// logging or authentication. To see or change what the inner handler
+// writes, use the hooks and accessors on response.Writer.
+type Middleware func(Handler) Handler
+
+// Chain wraps h in middlewares. The first middleware is the outermost, so
+// it runs first on the way in and last on the way out.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, Config{})
+}
+
+// ServeWithConfig is like Serve but lets the caller tune connection handling.
+func ServeWithConfig(port int, handler Handler, cfg Config) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
@@ -27,17 +159,106 @@ func Serve(port int, handler Handler) (*Server, error) {
 	s := &Server{
 		listener: listener,
 		handler:  handler,
+		cfg:      cfg,
 	}
+	s.baseCtx, s.cancelBase = context.WithCancel(context.Background())
 
 	go s.listen()
 	return s, nil
 }
 
+// Close stops accepting new connections. Connections already open are left
+// to finish on their own; use Shutdown to wait for them.
 func (s *Server) Close() error {
 	s.closed.Store(true)
 	return s.listener.Close()
 }
 
+// Shutdown stops accepting new connections, closes idle ones, and waits for
+// active requests to finish. Connections that finish a request during
+// shutdown are closed rather than kept alive. If ctx expires first, the
+// remaining connections are closed forcibly; Shutdown returns how many were
+// cut off that way along with ctx's error.
+func (s *Server) Shutdown(ctx context.Context) (int, error) {
+	err := s.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() == 0 {
+			return 0, err
+		}
+		select {
+		case <-ctx.Done():
+			if s.cancelBase != nil {
+				s.cancelBase()
+			}
+			return s.closeAllConns(), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for a request and returns how
+// many active ones remain.
+func (s *Server) closeIdleConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := 0
+	for conn, state := range s.conns {
+		if state == connIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		} else {
+			active++
+		}
+	}
+	return active
+}
+
+// closeAllConns closes every remaining connection and returns how many
+// there were.
+func (s *Server) closeAllConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.conns)
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return n
+}
+
+// trackConn records conn's state. It returns false if the server is shutting
+// down and the connection should not wait for, or start, a new request.
+func (s *Server) trackConn(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		// Shutdown may already have closed this connection as idle
+		if _, ok := s.conns[conn]; state == connIdle || !ok {
+			return false
+		}
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connState)
+	}
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
@@ -45,7 +266,7 @@ func (s *Server) listen() {
 			if s.closed.Load() {
 				return
 			}
-			fmt.Println("Error accepting connection:", err)
+			s.logger().Error("accepting connection", "err", err)
 			continue
 		}
 		go s.handle(conn)
@@ -54,21 +275,230 @@ func (s *Server) listen() {
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
+	defer s.untrackConn(conn)
 
-	// Parse request
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		fmt.Println("Error parsing request:", err)
+	// One parser per connection so bytes from pipelined requests survive
+	// between calls to Next. Requests are handled one at a time, which
+	// keeps responses in request order.
+	parser := request.NewParserWithLimits(conn, s.cfg.Limits)
+
+	for served := 0; ; served++ {
+		// Wait for the next request. Between requests the connection is
+		// idle; don't wait forever
+		if served > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.cfg.idleTimeout()))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(s.cfg.readHeaderTimeout()))
+		}
+		if !s.trackConn(conn, connIdle) {
+			return
+		}
+		if err := parser.WaitForRequest(); err != nil {
+			if !errors.Is(err, io.EOF) && !isTimeout(err) && !s.closed.Load() {
+				s.logger().Error("reading request", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+		if !s.trackConn(conn, connActive) {
+			return
+		}
+
+		// Parse request
+		conn.SetReadDeadline(time.Now().Add(s.cfg.readHeaderTimeout()))
+		req, err := parser.NextStream()
+		var w *response.Writer
+		if err == nil {
+			w = response.NewWriter(conn)
+			if req.RequestLine.Method == "HEAD" {
+				w.OmitBody()
+			}
+			s.expectContinue(conn, req, w)
+			conn.SetReadDeadline(deadline(s.cfg.ReadBodyTimeout))
+			if s.cfg.AutoContinue {
+				err = req.SendContinue()
+			}
+			if err == nil && s.cfg.DecodeRequestBodies {
+				err = req.DecodeBody()
+			}
+			if err == nil && !s.cfg.StreamBodies {
+				err = req.BufferBody()
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !isTimeout(err) {
+				s.logger().Warn("parsing request", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			// Tell the client why, rather than just dropping the connection.
+			// The details stay in the log: they can name socket addresses
+			if statusCode, ok := statusForParseError(err); ok {
+				conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+				ew := response.NewWriter(conn)
+				ew.WriteError(statusCode, response.StatusText(statusCode))
+				if s.cfg.OnRejected != nil {
+					s.cfg.OnRejected(conn.RemoteAddr().String(), ew)
+				}
+			}
+			return
+		}
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+
+		if served+1 >= s.cfg.maxRequestsPerConn() || wantsClose(req) || s.closed.Load() {
+			w.CloseAfterResponse()
+		}
+
+		// Call handler with a context that ends if the client hangs up
+		ctx, cancel := s.requestContext()
+		req = req.WithContext(ctx)
+		stopWatching := watchForDisconnect(conn, parser, cancel)
+		conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+		err = s.callHandler(req, w)
+		stopWatching()
+		cancel()
+		if errors.Is(err, errHandlerPanic) {
+			// If the response had already started, closing the
+			// connection is the only way to tell the client it's broken
+			if !w.Started() {
+				w.WriteError(response.StatusInternalServerError, "Internal Server Error")
+			}
+			return
+		}
+		if err != nil {
+			s.logger().Error("handler failed", "method", req.RequestLine.Method, "target", req.RequestLine.RequestTarget, "err", err)
+			return
+		}
+
+		// Only reuse the connection if the response was fully framed
+		if w.WillClose() || !w.Finished() {
+			return
+		}
+	}
+}
+
+// expectContinue arranges for 100 Continue to be sent through w when a
+// client that asked for it is about to be read from. If the final response
+// goes out first, the client won't send the body, so the connection can't
+// be reused.
+func (s *Server) expectContinue(conn net.Conn, req *request.Request, w *response.Writer) {
+	if !req.ExpectsContinue() {
 		return
 	}
+	req.OnContinue(func() error {
+		conn.SetWriteDeadline(deadline(s.cfg.WriteTimeout))
+		return w.WriteInformational(response.StatusContinue, nil)
+	})
+	w.OnWriteHeaders(func(response.StatusCode, *headers.Headers) {
+		if req.ExpectsContinue() {
+			w.CloseAfterResponse()
+		}
+	})
+}
+
+// requestContext derives a context for one request from the server's base
+// context, applying HandlerTimeout.
+func (s *Server) requestContext() (context.Context, context.CancelFunc) {
+	parent := s.baseCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	if s.cfg.HandlerTimeout > 0 {
+		return context.WithTimeout(parent, s.cfg.HandlerTimeout)
+	}
+	return context.WithCancel(parent)
+}
 
-	// Create response writer
-	w := response.NewWriter(conn)
+// watchForDisconnect reads from conn in the background while the handler
+// runs and calls cancel if the client closes the connection. This only
+// works once the request body has been consumed; for a streamed body that
+// the handler is still reading, disconnects show up as body read errors
+// instead. If the client sends more data (a pipelined request), it is kept
+// for the parser and watching stops. The returned stop func must be called
+// before the parser is used again.
+func watchForDisconnect(conn net.Conn, parser *request.Parser, cancel context.CancelFunc) (stop func()) {
+	if !parser.BodyConsumed() {
+		return func() {}
+	}
 
-	// Call handler
-	err = s.handler(req, w)
-	if err != nil {
-		fmt.Println("Handler error:", err)
-		return
+	conn.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := parser.ReadAhead()
+		if err != nil && !isTimeout(err) {
+			cancel()
+		}
+	}()
+
+	return func() {
+		// Interrupt the pending read, then wait for it so the parser is
+		// ours again
+		conn.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		conn.SetReadDeadline(time.Time{})
 	}
 }
+
+var errHandlerPanic = errors.New("handler panicked")
+
+// callHandler runs the handler, turning a panic into errHandlerPanic so one
+// bad request can't take the whole server down.
+func (s *Server) callHandler(req *request.Request, w *response.Writer) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.logger().Error("handler panicked",
+				"method", req.RequestLine.Method,
+				"target", req.RequestLine.RequestTarget,
+				"panic", v,
+				"stack", string(debug.Stack()))
+			err = fmt.Errorf("%w: %v", errHandlerPanic, v)
+		}
+	}()
+	return s.handler(req, w)
+}
+
+// statusForParseError maps a parser error to the status sent back to the
+// client. A read timeout gets a 408; other errors from the connection itself
+// (EOF, resets) have no status, as there is nobody left to tell.
+func statusForParseError(err error) (response.StatusCode, bool) {
+	switch {
+	case isTimeout(err):
+		return response.StatusRequestTimeout, true
+	case errors.Is(err, request.ErrMalformedRequest):
+		return response.StatusBadRequest, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported, true
+	case errors.Is(err, request.ErrNotImplemented):
+		return response.StatusNotImplemented, true
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		return response.StatusUnsupportedMediaType, true
+	case errors.Is(err, request.ErrExpectationFailed):
+		return response.StatusExpectationFailed, true
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusRequestURITooLong, true
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusRequestEntityTooLarge, true
+	default:
+		return 0, false
+	}
+}
+
+// wantsClose reports whether the client asked for the connection to be
+// closed after this request.
+func wantsClose(req *request.Request) bool {
+	return headers.HasToken(req.Headers.Get("Connection"), "close")
+}
+
+// deadline turns a timeout into a deadline from now, where zero means none.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,700 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+func okHandler(req *request.Request, w *response.Writer) error {
+	body := []byte("ok " + req.RequestLine.RequestTarget)
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
+
+// startConn runs s.handle on one end of an in-memory pipe and returns the
+// client end plus a channel closed once the server side has returned.
+func startConn(t *testing.T, s *Server) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	client, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handle(srv)
+		close(done)
+	}()
+	t.Cleanup(func() { client.Close() })
+	return client, done
+}
+
+func readResponse(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+func waitClosed(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not close the connection")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Several requests served on one connection
+	s := &Server{handler: okHandler}
+	conn, done := startConn(t, s)
+	br := bufio.NewReader(conn)
+
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		resp, body := readResponse(t, br)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "ok "+target, body)
+		assert.False(t, resp.Close)
+	}
+
+	// Test: Connection: close from the client ends the connection
+	_, err := io.WriteString(conn, "GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	resp, body := readResponse(t, br)
+	assert.Equal(t, "ok /last", body)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestKeepAliveRequestCap(t *testing.T) {
+	s := &Server{handler: okHandler, cfg: Config{MaxRequestsPerConn: 2}}
+	conn, done := startConn(t, s)
+	br := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn, "GET /a HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, _ := readResponse(t, br)
+	assert.False(t, resp.Close)
+
+	_, err = io.WriteString(conn, "GET /b HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	resp, _ = readResponse(t, br)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+}
+
+func TestKeepAliveIdleTimeout(t *testing.T) {
+	s := &Server{handler: okHandler, cfg: Config{IdleTimeout: 50 * time.Millisecond}}
+	conn, done := startConn(t, s)
+	br := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, br)
+
+	// Send nothing more; the server should give up on the idle connection
+	waitClosed(t, done)
+}
+
+func TestPipelining(t *testing.T) {
+	s := &Server{handler: okHandler}
+	conn, done := startConn(t, s)
+
+	// Write all requests before reading any response. net.Pipe is
+	// unbuffered, so the write has to happen concurrently with the reads.
+	go io.WriteString(conn, "GET /1 HTTP/1.1\r\n\r\n"+
+		"POST /2 HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"+
+		"GET /3 HTTP/1.1\r\nConnection: close\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	for _, target := range []string{"/1", "/2", "/3"} {
+		_, body := readResponse(t, br)
+		assert.Equal(t, "ok "+target, body)
+	}
+	waitClosed(t, done)
+}
+
+func TestStreamBodies(t *testing.T) {
+	var seen []string
+	echo := func(req *request.Request, w *response.Writer) error {
+		// Headers arrive before the body has been buffered
+		seen = append(seen, string(req.Body))
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			return err
+		}
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+			return err
+		}
+		_, err = w.WriteBody(body)
+		return err
+	}
+	s := &Server{handler: echo, cfg: Config{StreamBodies: true}}
+	conn, done := startConn(t, s)
+
+	go io.WriteString(conn, "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n"+
+		"POST / HTTP/1.1\r\nContent-Length: 2\r\nConnection: close\r\n\r\nxy")
+
+	br := bufio.NewReader(conn)
+	_, body := readResponse(t, br)
+	assert.Equal(t, "abcdef", body)
+	_, body = readResponse(t, br)
+	assert.Equal(t, "xy", body)
+	waitClosed(t, done)
+	assert.Equal(t, []string{"", ""}, seen)
+}
+
+func TestLimitErrorResponses(t *testing.T) {
+	cfg := Config{Limits: request.Limits{MaxRequestLineBytes: 16, MaxHeaderCount: 1, MaxBodyBytes: 4}}
+	tests := []struct {
+		raw    string
+		status int
+	}{
+		{"GET /this/is/far/too/long HTTP/1.1\r\n\r\n", 414},
+		{"GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\n\r\n", 431},
+		{"POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", 413},
+	}
+	for _, tt := range tests {
+		s := &Server{handler: okHandler, cfg: cfg}
+		conn, done := startConn(t, s)
+		go io.WriteString(conn, tt.raw)
+
+		resp, _ := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, resp.StatusCode)
+		assert.True(t, resp.Close)
+		waitClosed(t, done)
+	}
+}
+
+func TestParseErrorResponses(t *testing.T) {
+	tests := []struct {
+		raw    string
+		status int
+	}{
+		{"GET /\r\n\r\n", 400},
+		{"get / HTTP/1.1\r\n\r\n", 400},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", 400},
+		{"GET / HTTP/1.1\r\nContent-Length: abc\r\n\r\n", 400},
+		{"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", 400},
+		{"GET /bad%zz HTTP/1.1\r\n\r\n", 400},
+		{"BREW /pot HTTP/1.1\r\n\r\n", 501},
+		{"POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", 501},
+		{"GET / HTTP/2.0\r\n\r\n", 505},
+	}
+	for _, tt := range tests {
+		var rejected response.StatusCode
+		s := &Server{handler: okHandler, cfg: Config{OnRejected: func(remoteAddr string, w *response.Writer) {
+			rejected = w.StatusCode()
+		}}}
+		conn, done := startConn(t, s)
+		go io.WriteString(conn, tt.raw)
+
+		resp, body := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, resp.StatusCode, tt.raw)
+		// Just the reason, not the parser's error
+		assert.Equal(t, response.StatusText(response.StatusCode(tt.status))+"\n", body, tt.raw)
+		waitClosed(t, done)
+		assert.Equal(t, response.StatusCode(tt.status), rejected, tt.raw)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	s := &Server{handler: okHandler, cfg: Config{ReadHeaderTimeout: 50 * time.Millisecond}}
+	conn, done := startConn(t, s)
+
+	// Start a request and then stall, slowloris style
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: local")
+	require.NoError(t, err)
+
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 408, resp.StatusCode)
+	assert.Equal(t, "Request Timeout\n", body)
+	waitClosed(t, done)
+}
+
+func TestReadBodyTimeout(t *testing.T) {
+	s := &Server{handler: okHandler, cfg: Config{ReadBodyTimeout: 50 * time.Millisecond}}
+	conn, done := startConn(t, s)
+
+	// Headers arrive promptly, the body never finishes
+	_, err := io.WriteString(conn, "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nabc")
+	require.NoError(t, err)
+
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 408, resp.StatusCode)
+	waitClosed(t, done)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	handlerErr := make(chan error, 1)
+	h := func(req *request.Request, w *response.Writer) error {
+		err := okHandler(req, w)
+		handlerErr <- err
+		return err
+	}
+	s := &Server{handler: h, cfg: Config{WriteTimeout: 50 * time.Millisecond}}
+	conn, done := startConn(t, s)
+
+	// Send a request but never read the response
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+
+	select {
+	case err := <-handlerErr:
+		assert.True(t, isTimeout(err))
+	case <-time.After(2 * time.Second):
+		t.Fatal("write did not time out")
+	}
+	waitClosed(t, done)
+}
+
+func TestIdleConnectionClosedSilently(t *testing.T) {
+	s := &Server{handler: okHandler, cfg: Config{ReadHeaderTimeout: 50 * time.Millisecond}}
+	conn, done := startConn(t, s)
+
+	// A connection that never sends anything is closed without a 408
+	waitClosed(t, done)
+	n, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := func(req *request.Request, w *response.Writer) error {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		return okHandler(req, w)
+	}
+	s, err := ServeWithConfig(0, slow, Config{})
+	require.NoError(t, err)
+	addr := s.listener.Addr().String()
+
+	// One idle kept-alive connection and one in the middle of a request
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	idleReader := bufio.NewReader(idle)
+	_, err = io.WriteString(idle, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	readResponse(t, idleReader)
+
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = io.WriteString(busy, "GET /slow HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	type result struct {
+		forced int
+		err    error
+	}
+	finished := make(chan result)
+	go func() {
+		forced, err := s.Shutdown(context.Background())
+		finished <- result{forced, err}
+	}()
+
+	// The idle connection is closed straight away
+	_, err = idleReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// New connections are refused
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	// The active request finishes, then its connection is closed
+	close(release)
+	busyReader := bufio.NewReader(busy)
+	_, body := readResponse(t, busyReader)
+	assert.Equal(t, "ok /slow", body)
+	_, err = busyReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	r := <-finished
+	assert.NoError(t, r.err)
+	assert.Equal(t, 0, r.forced)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	stuck := func(req *request.Request, w *response.Writer) error {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+		return nil
+	}
+	s, err := ServeWithConfig(0, stuck, Config{})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	forced, err := s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, forced)
+
+	// The client sees its connection cut off and the handler is told
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request context was not cancelled")
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	// Test: Panic before anything is written gets a 500
+	s := &Server{handler: func(req *request.Request, w *response.Writer) error {
+		panic("boom")
+	}}
+	conn, done := startConn(t, s)
+	go io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+
+	// Test: Panic mid-response truncates it by closing the connection
+	s = &Server{handler: func(req *request.Request, w *response.Writer) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(100))
+		panic("boom")
+	}}
+	conn, done = startConn(t, s)
+	go io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	waitClosed(t, done)
+}
+
+func TestRequestContextCancelledOnDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	ctxErr := make(chan error, 1)
+	h := func(req *request.Request, w *response.Writer) error {
+		close(started)
+		<-req.Context().Done()
+		ctxErr <- req.Context().Err()
+		return nil
+	}
+	s := &Server{handler: h}
+	conn, done := startConn(t, s)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+
+	select {
+	case err := <-ctxErr:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled")
+	}
+	waitClosed(t, done)
+}
+
+func TestRequestContextHandlerTimeout(t *testing.T) {
+	h := func(req *request.Request, w *response.Writer) error {
+		<-req.Context().Done()
+		if req.Context().Err() != context.DeadlineExceeded {
+			return req.Context().Err()
+		}
+		return okHandler(req, w)
+	}
+	s := &Server{handler: h, cfg: Config{HandlerTimeout: 20 * time.Millisecond}}
+	conn, _ := startConn(t, s)
+
+	go io.WriteString(conn, "GET /slow HTTP/1.1\r\n\r\n")
+	_, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "ok /slow", body)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(req *request.Request, w *response.Writer) error {
+				order = append(order, name+" in")
+				err := next(req, w)
+				order = append(order, name+" out")
+				return err
+			}
+		}
+	}
+
+	// Observes what the inner handler wrote, and stamps a header on it
+	type observed struct {
+		status  response.StatusCode
+		bytes   int
+		headers string
+	}
+	var seen observed
+	observe := func(next Handler) Handler {
+		return func(req *request.Request, w *response.Writer) error {
+			w.OnWriteHeaders(func(statusCode response.StatusCode, h *headers.Headers) {
+				h.Set("X-Observed", "yes")
+			})
+			err := next(req, w)
+			seen = observed{w.StatusCode(), w.BytesWritten(), w.Headers().Get("Content-Length")}
+			return err
+		}
+	}
+
+	h := Chain(okHandler, trace("outer"), observe, trace("inner"))
+	s := &Server{handler: h}
+	conn, done := startConn(t, s)
+	go io.WriteString(conn, "GET /x HTTP/1.1\r\nConnection: close\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	waitClosed(t, done)
+
+	assert.Equal(t, "ok /x", body)
+	assert.Equal(t, "yes", resp.Header.Get("X-Observed"))
+	assert.Equal(t, observed{response.StatusOK, 5, "5"}, seen)
+	assert.Equal(t, []string{"outer in", "inner in", "inner out", "outer out"}, order)
+}
+
+func TestDecodeRequestBodies(t *testing.T) {
+	echo := func(req *request.Request, w *response.Writer) error {
+		body := req.Body
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+			return err
+		}
+		_, err := w.WriteBody(body)
+		return err
+	}
+	gzipped := func(data string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(data))
+		zw.Close()
+		return buf.String()
+	}
+	post := func(encoding, body string) string {
+		return fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", encoding, len(body), body)
+	}
+
+	tests := []struct {
+		cfg    Config
+		raw    string
+		status int
+		body   string
+	}{
+		{Config{DecodeRequestBodies: true}, post("gzip", gzipped("hello")), 200, "hello"},
+		// Off by default: the handler gets the encoded bytes
+		{Config{}, post("gzip", gzipped("hello")), 200, gzipped("hello")},
+		{Config{DecodeRequestBodies: true}, post("br", "xxxx"), 415, ""},
+		{Config{DecodeRequestBodies: true, Limits: request.Limits{MaxDecodedBodyBytes: 100}}, post("gzip", gzipped(strings.Repeat("a", 1000))), 413, ""},
+	}
+	for _, tt := range tests {
+		s := &Server{handler: echo, cfg: tt.cfg}
+		conn, done := startConn(t, s)
+		go io.WriteString(conn, tt.raw)
+
+		resp, body := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, resp.StatusCode)
+		if tt.status == 200 {
+			assert.Equal(t, tt.body, body)
+		}
+		waitClosed(t, done)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	const headers = "POST /upload HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"
+	echo := func(req *request.Request, w *response.Writer) error {
+		body, err := io.ReadAll(req.BodyReader())
+		if err != nil {
+			return err
+		}
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+			return err
+		}
+		_, err = w.WriteBody(body)
+		return err
+	}
+
+	for _, cfg := range []Config{{}, {StreamBodies: true}, {StreamBodies: true, AutoContinue: true}} {
+		// Test: The client only sends the body after 100 Continue
+		s := &Server{handler: echo, cfg: cfg}
+		conn, done := startConn(t, s)
+		br := bufio.NewReader(conn)
+		go io.WriteString(conn, headers)
+
+		resp, _ := readResponse(t, br)
+		assert.Equal(t, 100, resp.StatusCode)
+		go io.WriteString(conn, "hello")
+		resp, body := readResponse(t, br)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "hello", body)
+		assert.False(t, resp.Close)
+
+		conn.Close()
+		waitClosed(t, done)
+	}
+
+	// Test: A handler that answers without reading the body never invites
+	// it, and the connection is closed after
+	s := &Server{handler: okHandler, cfg: Config{StreamBodies: true}}
+	conn, done := startConn(t, s)
+	go io.WriteString(conn, headers)
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.True(t, resp.Close)
+	waitClosed(t, done)
+
+	// Test: Unknown expectations get a 417
+	s = &Server{handler: okHandler}
+	conn, done = startConn(t, s)
+	go io.WriteString(conn, "POST / HTTP/1.1\r\nExpect: teapot\r\nContent-Length: 1\r\n\r\nx")
+	resp, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 417, resp.StatusCode)
+	waitClosed(t, done)
+}
+
+func TestHead(t *testing.T) {
+	chunked := func(req *request.Request, w *response.Writer) error {
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+		if _, err := w.WriteChunkedBody([]byte("streamed")); err != nil {
+			return err
+		}
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+
+	for _, handler := range []Handler{okHandler, chunked} {
+		s := &Server{handler: handler}
+		conn, done := startConn(t, s)
+		br := bufio.NewReader(conn)
+
+		// Test: HEAD gets GET's headers and no body, and the connection
+		// stays usable
+		go io.WriteString(conn, "HEAD /page HTTP/1.1\r\n\r\nGET /page HTTP/1.1\r\n\r\n")
+		resp, err := http.ReadResponse(br, &http.Request{Method: "HEAD"})
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.False(t, resp.Close)
+		head := resp.Header.Clone()
+		headLength := resp.ContentLength
+
+		resp, body := readResponse(t, br)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.NotEmpty(t, body)
+		assert.Equal(t, resp.Header, head)
+		assert.Equal(t, resp.ContentLength, headLength)
+
+		conn.Close()
+		waitClosed(t, done)
+	}
+}
+
+func TestStreamLargeUpload(t *testing.T) {
+	// Counts the body without keeping it
+	count := func(req *request.Request, w *response.Writer) error {
+		n, err := io.Copy(io.Discard, req.BodyReader())
+		if err != nil {
+			return err
+		}
+		body := []byte(fmt.Sprint(n))
+		if err := w.WriteStatusLine(response.StatusOK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+			return err
+		}
+		_, err = w.WriteBody(body)
+		return err
+	}
+	// Just past the default; streamed bodies have no other reason to stop
+	// at any particular size
+	size := request.DefaultLimits.MaxBodyBytes + 1
+	head := fmt.Sprintf("POST /upload HTTP/1.1\r\nContent-Length: %d\r\n\r\n", size)
+	upload := func(conn net.Conn) {
+		io.WriteString(conn, head)
+		chunk := bytes.Repeat([]byte("x"), 64<<10)
+		for sent := 0; sent < size; sent += len(chunk) {
+			if _, err := conn.Write(chunk[:min(len(chunk), size-sent)]); err != nil {
+				return
+			}
+		}
+	}
+
+	// Test: The default limit turns it away
+	s := &Server{handler: count, cfg: Config{StreamBodies: true}}
+	conn, done := startConn(t, s)
+	go io.WriteString(conn, head)
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 413, resp.StatusCode)
+	waitClosed(t, done)
+
+	// Test: A raised limit, or none, streams it through
+	for _, limit := range []int{size, request.NoLimit} {
+		s := &Server{handler: count, cfg: Config{StreamBodies: true, Limits: request.Limits{MaxBodyBytes: limit}}}
+		conn, done := startConn(t, s)
+		go upload(conn)
+		resp, body := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, fmt.Sprint(size), body)
+		conn.Close()
+		waitClosed(t, done)
+	}
+}